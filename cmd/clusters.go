@@ -0,0 +1,64 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ClustersCmd = &cobra.Command{
+		Use:   "clusters",
+		Short: "Manage loginapp clusters configuration",
+	}
+	ClustersImportCmd = &cobra.Command{
+		Use:   "import KUBECONFIG [KUBECONFIG...]",
+		Short: "Import clusters from kubeconfig files",
+		Long: `
+Print the 'clusters' configuration of every cluster referenced by
+contexts of kubeconfig files. Directories are walked for kubeconfig
+files (non-recursive).
+
+Only cluster information is imported (server, certificate authority,
+TLS server name, proxy URL), user credentials are never imported.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var clusters []config.Cluster
+			cmd.SilenceUsage = true
+			for _, path := range args {
+				imported, err := config.ImportClusters(path, clustersImportContextFilter, config.Cluster{})
+				if err != nil {
+					return err
+				}
+				clusters = append(clusters, imported...)
+			}
+			out, err := config.MarshalYAML(struct{ Clusters []config.Cluster }{clusters}, true)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(out))
+			return nil
+		},
+	}
+	clustersImportContextFilter string
+)
+
+func init() {
+	ClustersImportCmd.Flags().StringVar(&clustersImportContextFilter, "context-filter", "", "Import only contexts matching this regular expression")
+	ClustersCmd.AddCommand(ClustersImportCmd)
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	"github.com/fydrah/loginapp/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	ConfigCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Manage loginapp configuration",
+	}
+	ConfigValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate loginapp configuration",
+		Long: `
+Load the configuration like 'loginapp serve' (same flags, environment
+vars and configuration file, with the same precedence) and print every
+problem found, with its configuration key:
+* checks performed by 'loginapp serve' at startup
+* unknown configuration keys
+* unreadable or invalid certificate files
+* redirect URL not ending with '/callback', or not matching the
+  listen address and TLS scheme
+* duplicate cluster names
+
+Exit with a non-zero status if errors are found, warnings do not
+prevent loginapp to start.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			problems, err := validate(new(config.App))
+			if err != nil {
+				return err
+			}
+			errors := 0
+			for _, p := range problems {
+				if !p.Warning {
+					errors++
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), p)
+			}
+			if errors > 0 {
+				return fmt.Errorf("invalid configuration: %d errors found", errors)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+			return nil
+		},
+	}
+	ConfigPrintCmd = &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective loginapp configuration",
+		Long: `
+Print the effective configuration, merged from flags, environment vars
+and configuration file like 'loginapp serve', with default values set and
+clusters imported. Secrets are redacted.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			cfg := new(config.App)
+			problems, err := validate(cfg)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				if !p.Warning {
+					log.Warningf("configuration is invalid, run 'loginapp config validate' for details")
+					break
+				}
+			}
+			out, err := config.MarshalYAML(cfg.Redacted(logging.Redacted), configPrintOmitEmpty)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(out))
+			return nil
+		},
+	}
+	ConfigSchemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON schema of loginapp configuration file",
+		Long: `
+Print the JSON schema of the configuration file. The schema can be used
+by editors to validate and complete configuration files, unknown keys
+are rejected.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Descriptions are the usage of configuration flags
+			descriptions := make(map[string]string)
+			flags := &cobra.Command{}
+			new(config.App).AddFlags(flags)
+			flags.Flags().VisitAll(func(f *pflag.Flag) {
+				descriptions[strings.Replace(f.Name, "-", ".", -1)] = f.Usage
+			})
+			out, err := json.MarshalIndent(config.Schema(descriptions), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			return nil
+		},
+	}
+	ConfigMigrateCmd = &cobra.Command{
+		Use:   "migrate FILE",
+		Short: "Migrate loginapp configuration file to the current format",
+		Long: `
+Rewrite a configuration file into the current format: deprecated keys
+are replaced by their new keys and the configuration version is set.
+Comments and keys order are kept. The migrated configuration is printed,
+unless --write is set.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			file := args[0]
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			out, deprecations, err := config.MigrateFile(data)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %v", file, err)
+			}
+			for _, d := range deprecations {
+				log.Infof("migrated %s to %s", d.Key, d.NewKey)
+			}
+			if !configMigrateWrite {
+				fmt.Fprint(cmd.OutOrStdout(), string(out))
+				return nil
+			}
+			if err := ioutil.WriteFile(file, out, info.Mode()); err != nil {
+				return err
+			}
+			log.Infof("configuration file %s migrated to version %s", file, config.CurrentVersion)
+			return nil
+		},
+	}
+	configPrintOmitEmpty bool
+	configMigrateWrite   bool
+)
+
+// validate returns the problems found by cfg.Validate,
+// and by the checks of the server at startup
+func validate(cfg *config.App) ([]config.Problem, error) {
+	problems, err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if err := server.CheckErrorsConfig(cfg.Web.Errors); err != nil {
+		problems = append(problems, config.Problem{Path: "web.errors", Message: err.Error()})
+	}
+	return problems, nil
+}
+
+func init() {
+	appFlags(ConfigValidateCmd)
+	appFlags(ConfigPrintCmd)
+	ConfigPrintCmd.Flags().BoolVar(&configPrintOmitEmpty, "omit-empty", false, "Omit empty values")
+	ConfigMigrateCmd.Flags().BoolVarP(&configMigrateWrite, "write", "w", false, "Write the migrated configuration to FILE instead of stdout")
+	ConfigCmd.AddCommand(ConfigValidateCmd)
+	ConfigCmd.AddCommand(ConfigPrintCmd)
+	ConfigCmd.AddCommand(ConfigSchemaCmd)
+	ConfigCmd.AddCommand(ConfigMigrateCmd)
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/spf13/viper"
+)
+
+func TestValidateErrorsConfig(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	for errorType, valid := range map[string]bool{"idp_denied": true, "unknown_error": false} {
+		viper.Set("web.errors", map[string]interface{}{errorType: map[string]interface{}{"help": "Contact the support"}})
+		problems, err := validate(new(config.App))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reported := false
+		for _, p := range problems {
+			if p.Path == "web.errors" && !p.Warning {
+				reported = true
+			}
+		}
+		if reported == valid {
+			t.Errorf("%s: web.errors problem reported: %t, problems: %v", errorType, reported, problems)
+		}
+	}
+}
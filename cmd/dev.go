@@ -0,0 +1,127 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/oidctest"
+	"github.com/fydrah/loginapp/pkg/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	DevCmd = &cobra.Command{
+		Use:   "dev",
+		Short: "Run loginapp with an embedded OIDC provider",
+		Long: `
+Run loginapp for development, with an in-process OpenID Connect
+provider instead of an identity provider. Users are selected on the
+provider login page without password, or logged in automatically
+with '--provider-autologin'.
+
+OIDC client and issuer settings are set to use the provider, other
+settings are loaded like 'loginapp serve'. Do not use in production.
+
+Users file format:
+
+  - username: jane
+    email: jane@example.com
+    groups: [developers]
+    claims:
+      department: engineering`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.SilenceUsage = true
+			var users []oidctest.User
+			if devUsersFile != "" {
+				var err error
+				if users, err = oidctest.LoadUsers(devUsersFile); err != nil {
+					log.Fatal(err)
+				}
+			}
+			redirectURL := devRedirectURL(viper.GetString("listen"), viper.GetBool("tls.enabled"))
+			provider, err := oidctest.Start(devProviderListen, oidctest.Config{
+				RedirectURIs: []string{redirectURL},
+				Users:        users,
+				AutoLogin:    devAutoLogin,
+			})
+			if err != nil {
+				log.Fatalf("failed to start oidc provider: %v", err)
+			}
+			defer provider.Close()
+			// Overrides OIDC settings of flags, env and configuration file
+			viper.Set("oidc.issuer.url", provider.Issuer())
+			viper.Set("oidc.issuer.insecureSkipVerify", true)
+			viper.Set("oidc.client.id", provider.Config.ClientID)
+			viper.Set("oidc.client.secret", provider.Config.ClientSecret)
+			viper.Set("oidc.client.redirectURL", redirectURL)
+			viper.SetDefault("secret", devSecret())
+			s := server.New(new(config.App))
+			s.Version = server.Version{GitVersion: GitVersion, GitHash: GitHash}
+			if err := s.Config.Init(); err != nil {
+				log.Fatal(err)
+			}
+			log.Warning("development mode, users are logged in without password")
+			if err := s.Run(); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	devProviderListen string
+	devUsersFile      string
+	devAutoLogin      string
+)
+
+func init() {
+	appFlags(DevCmd)
+	DevCmd.Flags().StringVar(&devProviderListen, "provider-listen", "127.0.0.1:5556", "Listen address of the OIDC provider")
+	DevCmd.Flags().StringVar(&devUsersFile, "provider-users", "", "YAML file listing the users of the OIDC provider (default users: admin, developer)")
+	DevCmd.Flags().StringVar(&devAutoLogin, "provider-autologin", "", "Username of the user logged in without showing the provider login page")
+}
+
+// devRedirectURL returns the redirect URL of loginapp
+// listening on listen, on the loopback address if
+// the listen address is unspecified
+func devRedirectURL(listen string, tls bool) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		log.Fatalf("invalid listen address %q: %v", listen, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/callback"
+}
+
+// devSecret returns a random application secret
+func devSecret() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -17,8 +17,10 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/fydrah/loginapp/pkg/logging"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
@@ -37,12 +39,19 @@ var (
 
 func init() {
 	rootCmd.AddCommand(ServeCmd)
+	rootCmd.AddCommand(ClustersCmd)
+	rootCmd.AddCommand(WebCmd)
+	rootCmd.AddCommand(ConfigCmd)
+	rootCmd.AddCommand(DevCmd)
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetLevel(log.InfoLevel)
+	log.AddHook(logging.ContextHook{})
 	cobra.OnInitialize(func() {
 		if verbose {
 			log.SetLevel(log.DebugLevel)
+			// Overrides log.level of the configuration
+			viper.Set("log.level", "debug")
 		}
 	})
 }
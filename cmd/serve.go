@@ -41,8 +41,16 @@ Loginapp supports three configuration formats:
   Ex: '--oidc-client-secret' --> 'LOGINAPP_OIDC_CLIENT_SECRET'
 
 Configuration precedence: flags > environment vars > configuration file`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindFlags(cmd)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
+			if cfgFile != "" {
+				viper.WatchConfig()
+			}
+			serveCfg.SetStrict(serveStrict)
 			s := server.New(serveCfg)
+			s.Version = server.Version{GitVersion: GitVersion, GitHash: GitHash}
 			if err := s.Config.Init(); err != nil {
 				log.Fatal(err)
 			}
@@ -62,13 +70,17 @@ Configuration precedence: flags > environment vars > configuration file`,
 			})
 		},
 	}
-	serveCfg     *config.App
-	serveCfgFile string
+	serveCfg    *config.App
+	serveStrict bool
+	cfgFile     string
+	// appFlagNames are the names of configuration flags
+	appFlagNames = make(map[string]bool)
 )
 
 func init() {
 	serveCfg = new(config.App)
-	flagsSetup()
+	appFlags(ServeCmd)
+	ServeCmd.Flags().BoolVar(&serveStrict, "strict", false, "Fail on unknown configuration keys, they are only reported otherwise")
 	// Configure flags
 	cobra.OnInitialize(func() {
 		configSetup()
@@ -76,28 +88,39 @@ func init() {
 	})
 }
 
-func flagsSetup() {
+// appFlags adds configuration flags to cmd
+func appFlags(cmd *cobra.Command) {
 	// App flags
-	serveCfg.AddFlags(ServeCmd)
-	ServeCmd.Flags().VisitAll(func(f *pflag.Flag) {
+	new(config.App).AddFlags(cmd)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		appFlagNames[f.Name] = true
+	})
+	// Static flags
+	cmd.Flags().StringVarP(&cfgFile, "config", "c", "", "Configuration file")
+}
+
+// bindFlags binds configuration flags of the command
+// being run, several commands share the same flags
+func bindFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !appFlagNames[f.Name] {
+			return
+		}
 		// replaces "-" by ".", ex: "oidc-client-secret" is stored as "oidc.client.secret"
 		// this is required for overrides by env vars and config
 		if err := viper.BindPFlag(strings.Replace(f.Name, "-", ".", -1), f); err != nil {
 			log.Fatal(err)
 		}
 	})
-	// Static flags
-	ServeCmd.Flags().StringVarP(&serveCfgFile, "config", "c", "", "Configuration file")
 }
 
 func configSetup() {
 	viper.SetConfigType("yaml")
-	if serveCfgFile != "" {
-		viper.SetConfigFile(serveCfgFile)
+	if cfgFile != "" {
+		viper.SetConfigFile(cfgFile)
 		if err := viper.ReadInConfig(); err != nil {
-			log.Fatalf("error while reading configuration file '%s': %v", serveCfgFile, err)
+			log.Fatalf("error while reading configuration file '%s': %v", cfgFile, err)
 		}
-		viper.WatchConfig()
 	}
 }
 
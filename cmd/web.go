@@ -0,0 +1,66 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/fydrah/loginapp/web"
+	"github.com/spf13/cobra"
+)
+
+var (
+	WebCmd = &cobra.Command{
+		Use:   "web",
+		Short: "Manage loginapp web assets and templates",
+	}
+	WebExportCmd = &cobra.Command{
+		Use:   "export DIRECTORY",
+		Short: "Export embedded assets and templates",
+		Long: `
+Export embedded assets and templates into DIRECTORY/assets and
+DIRECTORY/templates. Use them as a starting point for customization,
+with 'web.assetsDir' and 'web.templatesDir' options.
+
+Files found in these directories override embedded files one by one,
+unmodified files can be removed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			exports := map[string]fs.FS{
+				"assets":    web.Assets(),
+				"templates": web.Templates(),
+			}
+			for _, name := range []string{"assets", "templates"} {
+				written, err := web.Export(exports[name], filepath.Join(args[0], name), webExportOverwrite)
+				if err != nil {
+					return err
+				}
+				for _, f := range written {
+					fmt.Fprintln(cmd.OutOrStdout(), f)
+				}
+			}
+			return nil
+		},
+	}
+	webExportOverwrite bool
+)
+
+func init() {
+	WebExportCmd.Flags().BoolVar(&webExportOverwrite, "overwrite", false, "Overwrite existing files")
+	WebCmd.AddCommand(WebExportCmd)
+}
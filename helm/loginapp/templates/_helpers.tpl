@@ -97,4 +97,10 @@ tls:
   key: /tls/server/tls.key
 clusters:
   {{- toYaml .Values.config.clusters | nindent 2 }}
+{{- if .Values.config.discovery.enabled }}
+discovery:
+  enabled: true
+  namespace: {{ .Release.Namespace }}
+  labelSelector: {{ .Values.config.discovery.labelSelector | quote }}
+{{- end }}
 {{- end -}}
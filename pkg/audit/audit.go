@@ -0,0 +1,144 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit provides the audit log of issued credentials,
+// written to sinks separated from access logs
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventCredentialsIssued is the type of events emitted
+// when credentials are issued to a user
+const EventCredentialsIssued = "credentials_issued"
+
+// Event is an audit event. Events never contain raw
+// tokens, TokenFingerprint identifies the ID token
+type Event struct {
+	Time               time.Time `json:"time"`
+	Type               string    `json:"type"`
+	RequestID          string    `json:"request_id,omitempty"`
+	RemoteAddress      string    `json:"remote_address,omitempty"`
+	Issuer             string    `json:"issuer"`
+	ClientID           string    `json:"client_id"`
+	Username           string    `json:"username"`
+	Subject            string    `json:"subject"`
+	Groups             []string  `json:"groups"`
+	Clusters           []string  `json:"clusters"`
+	Scopes             []string  `json:"scopes"`
+	RefreshTokenIssued bool      `json:"refresh_token_issued"`
+	TokenFingerprint   string    `json:"token_fingerprint"`
+	TokenExpiry        time.Time `json:"token_expiry"`
+}
+
+// Fingerprint returns the fingerprint of an ID token: the
+// sha256 hash of its jti claim if any, of the raw token otherwise
+func Fingerprint(rawIDToken string, jti string) string {
+	if jti != "" {
+		rawIDToken = jti
+	}
+	sum := sha256.Sum256([]byte(rawIDToken))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Sink writes audit events
+type Sink interface {
+	// Name identifies the sink in logs and metrics
+	Name() string
+	Write(ctx context.Context, e Event) error
+	Close() error
+}
+
+// Logger writes audit events to sinks
+type Logger struct {
+	sinks  []Sink
+	failed *prometheus.CounterVec
+}
+
+// NewLogger returns a logger writing to the sinks configured
+// by cfg. Its metrics are registered to reg
+func NewLogger(cfg config.Audit, reg prometheus.Registerer) (*Logger, error) {
+	l := &Logger{
+		// Events a sink failed to write
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "loginapp_audit_events_failed_total",
+			Help: "The total number of audit events which failed to be written, by sink",
+		}, []string{"sink"}),
+	}
+	if err := reg.Register(l.failed); err != nil {
+		return nil, fmt.Errorf("failed to register metrics: %v", err)
+	}
+	if cfg.Stdout {
+		l.sinks = append(l.sinks, NewStdoutSink())
+	}
+	if cfg.File.Path != "" {
+		s, err := NewFileSink(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		l.sinks = append(l.sinks, s)
+	}
+	if cfg.Webhook.URL != "" {
+		l.sinks = append(l.sinks, NewWebhookSink(cfg.Webhook, l.report))
+	}
+	return l, nil
+}
+
+// Log writes e to every sink. Sink failures are
+// logged and do not prevent the issuance of credentials
+func (l *Logger) Log(ctx context.Context, e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	for _, s := range l.sinks {
+		if err := s.Write(ctx, e); err != nil {
+			l.report(ctx, s.Name(), err)
+		}
+	}
+}
+
+// report logs and counts an event sink failed to write
+func (l *Logger) report(ctx context.Context, sink string, err error) {
+	l.failed.WithLabelValues(sink).Inc()
+	log.WithContext(ctx).Errorf("failed to write audit event to %s: %v", sink, err)
+}
+
+// Close closes every sink
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", s.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to close audit sinks: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// documentedEvent is the event documented in the README
+var documentedEvent = Event{
+	Time:               time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+	Type:               EventCredentialsIssued,
+	RequestID:          "5c1e0c7a2b8d4e6f",
+	RemoteAddress:      "10.0.0.1:51234",
+	Issuer:             "https://dex.example.com",
+	ClientID:           "loginapp",
+	Username:           "oidc:jane@example.com",
+	Subject:            "CgRqYW5lEgVsb2NhbA",
+	Groups:             []string{"dev"},
+	Clusters:           []string{"prod", "staging"},
+	Scopes:             []string{"openid", "profile", "email", "groups"},
+	RefreshTokenIssued: true,
+	TokenFingerprint:   "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	TokenExpiry:        time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC),
+}
+
+// documentedJSON is the json document of documentedEvent
+const documentedJSON = `{
+  "time": "2021-03-01T10:00:00Z",
+  "type": "credentials_issued",
+  "request_id": "5c1e0c7a2b8d4e6f",
+  "remote_address": "10.0.0.1:51234",
+  "issuer": "https://dex.example.com",
+  "client_id": "loginapp",
+  "username": "oidc:jane@example.com",
+  "subject": "CgRqYW5lEgVsb2NhbA",
+  "groups": ["dev"],
+  "clusters": ["prod", "staging"],
+  "scopes": ["openid", "profile", "email", "groups"],
+  "refresh_token_issued": true,
+  "token_fingerprint": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+  "token_expiry": "2021-03-02T10:00:00Z"
+}`
+
+// assertDocumented checks that b is the documented json document
+func assertDocumented(t *testing.T, b []byte) {
+	t.Helper()
+	var want bytes.Buffer
+	if err := json.Compact(&want, []byte(documentedJSON)); err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimSpace(b); !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("got event\n%s\nwant\n%s", got, want.Bytes())
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// sha256sum of "test"
+	if got, want := Fingerprint("test", ""), documentedEvent.TokenFingerprint; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if Fingerprint("raw", "jti") != Fingerprint("other", "jti") {
+		t.Error("the fingerprint does not use the jti claim")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s, err := NewFileSink(config.AuditFile{Path: path, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(context.Background(), documentedEvent); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte("}\n")) || bytes.Count(b, []byte("\n")) != 1 {
+		t.Errorf("the event is not a json line: %q", b)
+	}
+	assertDocumented(t, b)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received []*http.Request
+	var bodies [][]byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, b)
+	}))
+	defer ts.Close()
+
+	s := NewWebhookSink(config.AuditWebhook{
+		URL:     ts.URL,
+		Timeout: 5 * time.Second,
+		Headers: map[string]string{"Authorization": "Bearer xxx"},
+	}, func(_ context.Context, _ string, err error) {
+		t.Errorf("unexpected webhook error: %v", err)
+	})
+	if err := s.Write(context.Background(), documentedEvent); err != nil {
+		t.Fatal(err)
+	}
+	// Close sends queued events
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("got %d webhook requests, want 1", len(received))
+	}
+	r := received[0]
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer xxx" {
+		t.Errorf("unexpected webhook request %s, headers %v", r.Method, r.Header)
+	}
+	if got := r.Header.Get("X-Request-ID"); got != documentedEvent.RequestID {
+		t.Errorf("got request ID %q, want %q", got, documentedEvent.RequestID)
+	}
+	assertDocumented(t, bodies[0])
+	if err := s.Write(context.Background(), documentedEvent); err == nil {
+		t.Error("expected an error writing to a closed sink")
+	}
+}
+
+func TestWebhookSinkQueue(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	var mu sync.Mutex
+	var reported []error
+	s := NewWebhookSink(config.AuditWebhook{URL: ts.URL, Timeout: 100 * time.Millisecond}, func(_ context.Context, _ string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, err)
+	})
+
+	// Writes do not wait for the webhook, and
+	// fail once the queue is full
+	start := time.Now()
+	queued, dropped := 0, 0
+	for i := 0; i < webhookQueueSize+2; i++ {
+		if err := s.Write(context.Background(), documentedEvent); err != nil {
+			if !strings.Contains(err.Error(), "queue full") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			dropped++
+		} else {
+			queued++
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("writes waited for the webhook: %s", elapsed)
+	}
+	if dropped == 0 {
+		t.Error("no event dropped with a full queue")
+	}
+
+	// Close cancels pending requests after the timeout,
+	// every queued event is reported
+	start = time.Now()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("close waited %s", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != queued {
+		t.Errorf("got %d events reported, want %d", len(reported), queued)
+	}
+}
+
+func TestLoggerMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	reg := prometheus.NewRegistry()
+	cfg := config.Audit{Webhook: config.AuditWebhook{URL: ts.URL, Timeout: 5 * time.Second}}
+	l, err := NewLogger(cfg, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log(context.Background(), documentedEvent)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.ToFloat64(l.failed.WithLabelValues("webhook")); n != 1 {
+		t.Errorf("got %v failed events, want 1", n)
+	}
+	if _, err := NewLogger(cfg, reg); err == nil {
+		t.Error("expected an error registering audit metrics twice")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// writerSink writes events as json lines
+type writerSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewStdoutSink returns a sink writing events
+// as json lines to stdout
+func NewStdoutSink() Sink {
+	return &writerSink{name: "stdout", w: os.Stdout}
+}
+
+// NewFileSink returns a sink writing events as json
+// lines to a file, rotated as configured by cfg
+func NewFileSink(cfg config.AuditFile) (Sink, error) {
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %v", err)
+	}
+	f.Close()
+	return &writerSink{name: "file", w: &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSize,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAge,
+	}}, nil
+}
+
+func (s *writerSink) Name() string {
+	return s.name
+}
+
+func (s *writerSink) Write(_ context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
+
+// webhookQueueSize is the number of events waiting to
+// be sent to the webhook, events are dropped beyond
+const webhookQueueSize = 1000
+
+// webhookSink sends events with POST requests,
+// from a queue consumed in the background
+type webhookSink struct {
+	cfg    config.AuditWebhook
+	client *http.Client
+	// report is called with events which
+	// failed to be sent from the queue
+	report func(ctx context.Context, sink string, err error)
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+	// ctx cancels pending requests on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWebhookSink returns a sink sending events as json
+// documents to a webhook. Writes only queue events, report
+// is called with events which failed to be sent
+func NewWebhookSink(cfg config.AuditWebhook, report func(ctx context.Context, sink string, err error)) Sink {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &webhookSink{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &logging.Transport{Base: http.DefaultTransport},
+		},
+		report: report,
+		queue:  make(chan Event, webhookQueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go s.run()
+	return s
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+// Write queues e, it fails if the queue is full
+func (s *webhookSink) Write(_ context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("sink closed, event dropped")
+	}
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return fmt.Errorf("queue full, event dropped")
+	}
+}
+
+// run sends queued events until the queue is closed
+func (s *webhookSink) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		// Requests are not canceled with the request of the user
+		ctx := logging.WithRequestID(s.ctx, e.RequestID)
+		if err := s.send(ctx, e); err != nil && s.report != nil {
+			s.report(ctx, s.Name(), err)
+		}
+	}
+}
+
+func (s *webhookSink) send(ctx context.Context, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close sends queued events, requests still
+// pending after the webhook timeout are canceled
+func (s *webhookSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	timer := time.NewTimer(s.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+	case <-timer.C:
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package claims provides helpers to read ID token claims
+// and to render claim based expressions
+package claims
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Claims is the decoded set of claims of an ID token
+type Claims map[string]interface{}
+
+// Get returns the claim value found at path. Path is a
+// dot separated list of keys, numeric keys are used as
+// index for list claims (ex: "groups.0", "address.country")
+func (c Claims) Get(path string) (interface{}, bool) {
+	var v interface{} = map[string]interface{}(c)
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+// String returns the string representation of the claim found at path
+func (c Claims) String(path string) (string, error) {
+	v, ok := c.Get(path)
+	if !ok {
+		return "", fmt.Errorf("claim %q not found", path)
+	}
+	return ToString(v)
+}
+
+// Value returns the claim found at path, as used by templates:
+// scalars are converted to strings (see ToString), lists and
+// objects are returned as is. Missing claims return an error
+func (c Claims) Value(path string) (interface{}, error) {
+	v, ok := c.Get(path)
+	if !ok {
+		return nil, fmt.Errorf("claim %q not found", path)
+	}
+	if s, err := ToString(v); err == nil {
+		return s, nil
+	}
+	return v, nil
+}
+
+// Strings returns the claim found at path as a list of strings.
+// A single string claim is returned as a one element list,
+// values which cannot be converted are skipped
+func (c Claims) Strings(path string) []string {
+	var values []string
+	v, ok := c.Get(path)
+	if !ok {
+		return nil
+	}
+	list, isList := v.([]interface{})
+	if !isList {
+		list = []interface{}{v}
+	}
+	for _, item := range list {
+		if s, err := ToString(item); err == nil {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// Redact returns a copy of c where claims found
+// at paths (see Get) are replaced by replacement
+func (c Claims) Redact(paths []string, replacement string) Claims {
+	redacted, _ := redact(map[string]interface{}(c), paths, replacement).(map[string]interface{})
+	return Claims(redacted)
+}
+
+func redact(v interface{}, paths []string, replacement string) interface{} {
+	children := make(map[string][]string)
+	leaves := make(map[string]bool)
+	for _, p := range paths {
+		if parts := strings.SplitN(p, ".", 2); len(parts) == 2 {
+			children[parts[0]] = append(children[parts[0]], parts[1])
+		} else {
+			leaves[p] = true
+		}
+	}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(node))
+		for k, item := range node {
+			switch {
+			case leaves[k]:
+				m[k] = replacement
+			case len(children[k]) > 0:
+				m[k] = redact(item, children[k], replacement)
+			default:
+				m[k] = item
+			}
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(node))
+		for i, item := range node {
+			key := strconv.Itoa(i)
+			switch {
+			case leaves[key]:
+				l[i] = replacement
+			case len(children[key]) > 0:
+				l[i] = redact(item, children[key], replacement)
+			default:
+				l[i] = item
+			}
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// ToString converts a claim value to a string. Numbers are
+// rendered without exponent when they are integers, lists
+// and objects cannot be converted and return an error
+func ToString(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+			return strconv.FormatInt(int64(value), 10), nil
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case json.Number:
+		return value.String(), nil
+	case nil:
+		return "", fmt.Errorf("claim is null")
+	default:
+		return "", fmt.Errorf("claim of type %T cannot be used as a string", v)
+	}
+}
+
+var (
+	templateActions = regexp.MustCompile(`(?s){{.*?}}`)
+	// indexedFields matches field chains with a numeric
+	// key, like ".groups.0", which go templates reject
+	indexedFields = regexp.MustCompile(`(^|[\s(|{])\.([A-Za-z_]\w*(?:\.\w+)*\.\d+(?:\.\w+)*)`)
+)
+
+// Template is a go template evaluated against claims,
+// used to build values like usernames from claims
+type Template struct {
+	tmpl *template.Template
+}
+
+// NewTemplate parses text as a claims template.
+// Claims are available at the template root (ex: "{{ .email }}")
+// and through the "claim" function for nested or list
+// claims (ex: "{{ claim "groups.0" }}"). List indexes can also
+// be used in field chains (ex: "{{ .groups.0 }}")
+func NewTemplate(name string, text string) (*Template, error) {
+	var c Claims
+	text = templateActions.ReplaceAllStringFunc(text, func(action string) string {
+		return indexedFields.ReplaceAllString(action, `$1(claim "$2")`)
+	})
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			// placeholder, replaced by the claims bound function at render time
+			"claim": c.Value,
+		}).
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Render executes the template against c.
+// Every claim used by the template must exist and must
+// be convertible to a string (see ToString)
+func (t *Template) Render(c Claims) (string, error) {
+	values := make(map[string]interface{}, len(c))
+	for k, v := range c {
+		// Scalars are converted so that numbers and booleans
+		// are rendered consistently, complex claims are kept
+		// as is to be used with "index" or "range"
+		if s, err := ToString(v); err == nil {
+			values[k] = s
+		} else {
+			values[k] = v
+		}
+	}
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	b := new(bytes.Buffer)
+	if err := tmpl.Funcs(template.FuncMap{"claim": c.Value}).Execute(b, values); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package claims
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStrings(t *testing.T) {
+	c := Claims{
+		"groups": []interface{}{"dev", 42.0, map[string]interface{}{"skipped": true}},
+		"group":  "ops",
+		"nested": map[string]interface{}{"roles": []interface{}{"admin"}},
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"groups", []string{"dev", "42"}},
+		{"group", []string{"ops"}},
+		{"nested.roles", []string{"admin"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		if got := c.Strings(tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	c := Claims{
+		"email":   "jane@example.com",
+		"address": map[string]interface{}{"street": "1 main st", "country": "fr"},
+		"groups":  []interface{}{"dev", "ops"},
+	}
+	want := Claims{
+		"email":   "REDACTED",
+		"address": map[string]interface{}{"street": "REDACTED", "country": "fr"},
+		"groups":  []interface{}{"dev", "REDACTED"},
+	}
+	got := c.Redact([]string{"email", "address.street", "groups.1", "missing.path"}, "REDACTED")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if c["email"] != "jane@example.com" || c["address"].(map[string]interface{})["street"] != "1 main st" {
+		t.Errorf("Redact modified the claims: %v", c)
+	}
+}
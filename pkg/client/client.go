@@ -3,27 +3,42 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	oidc "github.com/coreos/go-oidc"
 	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	"github.com/fydrah/loginapp/pkg/tracing"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/oauth2"
 )
 
 var c *Client
 
+var (
+	// ErrTokenExchange is returned when an authorization
+	// code cannot be exchanged for tokens
+	ErrTokenExchange = errors.New("token exchange failed")
+	// ErrTokenVerification is returned when
+	// an ID token cannot be verified
+	ErrTokenVerification = errors.New("id token verification failed")
+)
+
 // Client is an OpenID client, it handles all OIDC/OAuth2 interactions
 // between the provider and the creator of this Client
 type Client struct {
@@ -32,11 +47,15 @@ type Client struct {
 	Verifier   *oidc.IDTokenVerifier
 	Scopes     []string
 	HTTPClient *http.Client
+	// Metrics are not registered by New, see Metrics.Register
+	Metrics *Metrics
+	keys    *keysTransport
 }
 
 func New(cfg *config.OIDC) *Client {
 	c = new(Client)
 	c.Config = cfg
+	c.Metrics = NewMetrics()
 	c.PrepareScopes()
 	return c
 }
@@ -68,8 +87,10 @@ func (c *Client) AuthCodeURL(r *http.Request, secret string) string {
 	// Currently don't know if it can be achieved without session affinity
 	// or external storage (memcached, redis..)
 	authCodeURL = c.OAuth2Config().AuthCodeURL(GenerateState(r, secret), extraAuthCodeOptions...)
-	log.Debugf("auth code url: %s", authCodeURL)
-	log.Debugf("request token with the following scopes: %v", c.Scopes)
+	if u, err := url.Parse(authCodeURL); err == nil {
+		log.WithContext(r.Context()).Debugf("auth code url: %s", logging.RedactQuery(u, []string{"state"}))
+	}
+	log.WithContext(r.Context()).Debugf("request token with the following scopes: %v", c.Scopes)
 	return authCodeURL
 }
 
@@ -87,25 +108,55 @@ func VerifyState(r *http.Request, s string, secret string) bool {
 	return s == state
 }
 
+// RefreshTokenMAC returns a MAC binding a refresh token to the
+// ID token issued with it, so that forms posting back tokens
+// cannot submit a refresh token not issued by the provider
+func RefreshTokenMAC(idToken string, refreshToken string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(idToken))
+	mac.Write([]byte{0})
+	mac.Write([]byte(refreshToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRefreshTokenMAC verifies a MAC returned by RefreshTokenMAC
+func VerifyRefreshTokenMAC(idToken string, refreshToken string, m string, secret string) bool {
+	return hmac.Equal([]byte(m), []byte(RefreshTokenMAC(idToken, refreshToken, secret)))
+}
+
 // AuthCodeToToken converts an authorization code into a IDToken
 func (c *Client) AuthCodeToIDToken(ctx context.Context, authCode string) (*oauth2.Token, string, *oidc.IDToken, error) {
-	clientCtx := c.Context()
-	token, err := c.OAuth2Config().Exchange(clientCtx, authCode)
+	// The request context carries the request ID
+	// and the trace context to the provider
+	exchangeCtx, span := tracing.Tracer().Start(ctx, "oidc.TokenExchange")
+	start := time.Now()
+	token, err := c.OAuth2Config().Exchange(oidc.ClientContext(exchangeCtx, c.HTTPClient), authCode)
+	c.Metrics.exchange.WithLabelValues(c.Config.Issuer.URL).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
 	if err != nil {
-		log.Errorf("token exchange failed with context %v and authCode %v", clientCtx, authCode)
-		return nil, "", nil, err
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrTokenExchange, err)
 	}
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
-		return nil, "", nil, fmt.Errorf("no id_token in token response")
+		return nil, "", nil, fmt.Errorf("%w: no id_token in token response", ErrTokenExchange)
 	}
-	idToken, vErr := c.Verifier.Verify(ctx, rawIDToken)
+	idToken, vErr := c.Verify(ctx, rawIDToken)
 	if vErr != nil {
-		return nil, "", nil, err
+		return nil, "", nil, fmt.Errorf("%w: %v", ErrTokenVerification, vErr)
 	}
 	return token, rawIDToken, idToken, nil
 }
 
+// Verify verifies a raw ID token
+func (c *Client) Verify(ctx context.Context, rawIDToken string) (*oidc.IDToken, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "oidc.Verify")
+	start := time.Now()
+	idToken, err := c.Verifier.Verify(ctx, rawIDToken)
+	c.Metrics.verification.WithLabelValues(c.Config.Issuer.URL).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	return idToken, err
+}
+
 // ExtractClaims returns claims for a given IDToken
 func ExtractClaims(t *oidc.IDToken) (map[string]interface{}, error) {
 	var (
@@ -128,6 +179,36 @@ func ExtractClaims(t *oidc.IDToken) (map[string]interface{}, error) {
 	return jsonClaims, nil
 }
 
+// DecodeTokenHeader returns the decoded JOSE header of a raw JWT.
+// The token signature is not verified, this must be done
+// by the Verifier before
+func DecodeTokenHeader(rawToken string) (map[string]interface{}, error) {
+	var header map[string]interface{}
+	parts := strings.Split(rawToken, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("malformed jwt, expected 3 parts got %d", len(parts))
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt header: %v", err)
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal jwt header: %v", err)
+	}
+	return header, nil
+}
+
+// GrantedScopes returns the scopes granted for a token.
+// As stated by RFC 6749 section 5.1, the "scope" parameter
+// of the token response is omitted by the provider when granted
+// scopes are identical to the requested ones
+func (c *Client) GrantedScopes(t *oauth2.Token) []string {
+	if scope, ok := t.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+	return c.Scopes
+}
+
 // Context returns Client context
 func (c *Client) Context() context.Context {
 	return oidc.ClientContext(context.Background(), c.HTTPClient)
@@ -136,11 +217,6 @@ func (c *Client) Context() context.Context {
 // PrepareScopes setup scopes slice based on the client configuration
 func (c *Client) PrepareScopes() {
 	c.Scopes = append(c.Scopes, c.Config.Scopes...)
-
-	if len(c.Config.Extra.Scopes) > 0 {
-		log.Warning("[DEPRECATED] Using 'oidc.extra.scopes' is deprecated, please use 'oidc.scopes' instead to override default oidc scopes")
-	}
-	c.Scopes = append(c.Scopes, c.Config.Extra.Scopes...)
 	// Prepare cross client auth
 	// see https://github.com/coreos/dex/blob/master/Documentation/custom-scopes-claims-clients.md
 	for _, crossClient := range c.Config.CrossClients {
@@ -156,7 +232,12 @@ func (c *Client) PrepareScopes() {
 func (c *Client) ProviderSetup() error {
 	if err := backoff.Retry(func() error {
 		var bErr error
-		if c.Provider, bErr = oidc.NewProvider(c.Context(), c.Config.Issuer.URL); bErr != nil {
+		ctx, span := tracing.Tracer().Start(c.Context(), "oidc.Discovery")
+		start := time.Now()
+		c.Provider, bErr = oidc.NewProvider(ctx, c.Config.Issuer.URL)
+		c.Metrics.discovery.WithLabelValues(c.Config.Issuer.URL).Observe(time.Since(start).Seconds())
+		tracing.End(span, bErr)
+		if bErr != nil {
 			log.Errorf("failed to query provider %q: %v", c.Config.Issuer.URL, bErr)
 			return bErr
 		}
@@ -169,10 +250,12 @@ func (c *Client) ProviderSetup() error {
 		//
 		// See: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
 		ScopesSupported []string `json:"scopes_supported"`
+		JWKSURI         string   `json:"jwks_uri"`
 	}
 	if err := c.Provider.Claims(&ss); err != nil {
 		return fmt.Errorf("failed to parse provider scopes_supported: %v", err)
 	}
+	c.keys.jwksURI.Store(ss.JWKSURI)
 
 	// Ugly. Should be moved to an other place, and should comply
 	// go-oidc doc: go doc go-oidc.ScopeOfflineAccess
@@ -211,8 +294,9 @@ func (c *Client) TLSSetup() error {
 			return fmt.Errorf("no certs found in root CA file %q", c.Config.Issuer.RootCA)
 		}
 	}
-	c.HTTPClient = &http.Client{
-		Transport: &http.Transport{
+	c.keys = &keysTransport{
+		metrics: c.Metrics,
+		base: &http.Transport{
 			TLSClientConfig: &tlsConfig,
 			Proxy:           http.ProxyFromEnvironment,
 			Dial: (&net.Dialer{
@@ -223,6 +307,9 @@ func (c *Client) TLSSetup() error {
 			ExpectContinueTimeout: 1 * time.Second,
 		},
 	}
+	c.HTTPClient = &http.Client{
+		Transport: &logging.Transport{Base: otelhttp.NewTransport(c.keys)},
+	}
 	return nil
 }
 
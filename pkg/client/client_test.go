@@ -0,0 +1,71 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestVerifyRefreshTokenMAC(t *testing.T) {
+	mac := RefreshTokenMAC("id-token", "refresh-token", "secret")
+	tests := []struct {
+		idToken      string
+		refreshToken string
+		mac          string
+		secret       string
+		valid        bool
+	}{
+		{"id-token", "refresh-token", mac, "secret", true},
+		{"id-token", "forged", mac, "secret", false},
+		{"other-id-token", "refresh-token", mac, "secret", false},
+		{"id-token", "refresh-token", mac, "other-secret", false},
+		{"id-token", "refresh-token", "", "secret", false},
+	}
+	for i, tt := range tests {
+		if valid := VerifyRefreshTokenMAC(tt.idToken, tt.refreshToken, tt.mac, tt.secret); valid != tt.valid {
+			t.Errorf("%d: got %v, want %v", i, valid, tt.valid)
+		}
+	}
+}
+
+func TestDecodeTokenHeader(t *testing.T) {
+	// {"alg":"RS256","kid":"key"}
+	header, err := DecodeTokenHeader("eyJhbGciOiJSUzI1NiIsImtpZCI6ImtleSJ9.e30.sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "RS256" || header["kid"] != "key" {
+		t.Errorf("unexpected header %v", header)
+	}
+	for _, token := range []string{"", "not-a-jwt", "!!!.e30.sig", "bm90LWpzb24.e30.sig"} {
+		if _, err := DecodeTokenHeader(token); err == nil {
+			t.Errorf("%q: expected an error", token)
+		}
+	}
+}
+
+func TestGrantedScopes(t *testing.T) {
+	c := &Client{Scopes: []string{"openid", "email", "groups"}}
+	token := new(oauth2.Token)
+	if got := c.GrantedScopes(token); len(got) != 3 {
+		t.Errorf("requested scopes are granted when the scope parameter is omitted, got %v", got)
+	}
+	token = token.WithExtra(map[string]interface{}{"scope": "openid email"})
+	if got := c.GrantedScopes(token); len(got) != 2 || got[0] != "openid" || got[1] != "email" {
+		t.Errorf("got %v, want [openid email]", got)
+	}
+}
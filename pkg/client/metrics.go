@@ -0,0 +1,101 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsPrefix is the prefix of client metrics,
+// identical to the server metrics prefix
+const metricsPrefix = "loginapp_"
+
+// Metrics are the metrics of requests to the provider
+type Metrics struct {
+	discovery    *prometheus.HistogramVec
+	exchange     *prometheus.HistogramVec
+	verification *prometheus.HistogramVec
+	keysAge      prometheus.GaugeFunc
+	// keysRefreshedAt is the unix time in nanoseconds of
+	// the last signing keys refresh, 0 if never refreshed
+	keysRefreshedAt int64
+}
+
+// NewMetrics returns unregistered client metrics, see Register
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		discovery: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: metricsPrefix + "oidc_discovery_duration_seconds",
+			Help: "Duration of OpenID provider discovery in seconds",
+		}, []string{"provider"}),
+		exchange: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: metricsPrefix + "oidc_token_exchange_duration_seconds",
+			Help: "Duration of authorization code exchanges in seconds",
+		}, []string{"provider"}),
+		// Including the signing keys refresh if any
+		verification: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: metricsPrefix + "oidc_token_verification_duration_seconds",
+			Help: "Duration of ID token verifications in seconds",
+		}, []string{"provider"}),
+	}
+	// NaN if the signing keys were never refreshed
+	m.keysAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: metricsPrefix + "oidc_signing_keys_age_seconds",
+		Help: "Seconds since the provider signing keys were last refreshed",
+	}, func() float64 {
+		t := atomic.LoadInt64(&m.keysRefreshedAt)
+		if t == 0 {
+			return math.NaN()
+		}
+		return time.Since(time.Unix(0, t)).Seconds()
+	})
+	return m
+}
+
+// Register registers the client metrics to reg
+func (m *Metrics) Register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{m.discovery, m.exchange, m.verification, m.keysAge} {
+		if err := reg.Register(c); err != nil {
+			return fmt.Errorf("failed to register client metrics: %v", err)
+		}
+	}
+	return nil
+}
+
+// keysTransport records signing keys refreshes, which
+// are performed by the provider key set with our client
+type keysTransport struct {
+	base    http.RoundTripper
+	metrics *Metrics
+	// jwksURI is the signing keys url, set once the
+	// provider is discovered
+	jwksURI atomic.Value
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *keysTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if uri, _ := t.jwksURI.Load().(string); err == nil && uri != "" &&
+		resp.StatusCode == http.StatusOK && r.URL.String() == uri {
+		atomic.StoreInt64(&t.metrics.keysRefreshedAt, time.Now().UnixNano())
+	}
+	return resp, err
+}
@@ -15,19 +15,40 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/claims"
 	"github.com/spf13/cobra"
 )
 
 // App is the loginapp configuration set
 type App struct {
-	Name     string
-	Listen   string
-	Secret   string
-	OIDC     OIDC
-	TLS      TLS
-	Web      Web
-	Metrics  Metrics
-	Clusters []Cluster
+	// Version is the version of the configuration
+	// format, CurrentVersion if empty
+	Version   string
+	Name      string
+	Listen    string
+	Secret    string
+	OIDC      OIDC
+	TLS       TLS
+	Web       Web
+	Metrics   Metrics
+	Clusters  []Cluster
+	Discovery Discovery
+	Log       Log
+	Tracing   Tracing
+	Audit     Audit
+	Debug     Debug
+
+	// strict makes unknown configuration keys errors
+	strict bool
+}
+
+// SetStrict makes Init fail on unknown configuration
+// keys, they are only reported otherwise
+func (a *App) SetStrict(strict bool) {
+	a.strict = strict
 }
 
 // AddFlags init common App flags
@@ -39,8 +60,16 @@ func (a *App) AddFlags(cmd *cobra.Command) {
 	a.TLS.AddFlags(cmd)
 	a.Web.AddFlags(cmd)
 	a.Metrics.AddFlags(cmd)
+	a.Discovery.AddFlags(cmd)
+	a.Log.AddFlags(cmd)
+	a.Tracing.AddFlags(cmd)
+	a.Audit.AddFlags(cmd)
+	a.Debug.AddFlags(cmd)
 }
 
+// DefaultScopes are the scopes requested by default
+var DefaultScopes = []string{"openid", "profile", "email", "groups"}
+
 // OIDC is the OpenID configuration
 type OIDC struct {
 	Client         OIDCClient
@@ -55,7 +84,7 @@ type OIDC struct {
 func (o *OIDC) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().Bool("oidc-offlineasscope", false, "Issue a refresh token for offline access")
 	cmd.Flags().StringSlice("oidc-crossclients", nil, "Issue token on behalf of this list of client IDs")
-	cmd.Flags().StringSlice("oidc-scopes", []string{"openid", "profile", "email", "groups"}, "List of scopes to request. Updating this parameter will override existing scopes.")
+	cmd.Flags().StringSlice("oidc-scopes", DefaultScopes, "List of scopes to request. Updating this parameter will override existing scopes.")
 	o.Client.AddFlags(cmd)
 	o.Issuer.AddFlags(cmd)
 	o.Extra.AddFlags(cmd)
@@ -91,24 +120,181 @@ func (oi *OIDCIssuer) AddFlags(cmd *cobra.Command) {
 
 // OIDCIssuer is the extra OpenID configuration supported
 type OIDCExtra struct {
-	Scopes       []string
 	AuthCodeOpts map[string]string
 }
 
 // AddFlags init oidc extra flags
 func (oe *OIDCExtra) AddFlags(cmd *cobra.Command) {
-	cmd.Flags().StringSlice("oidc-extra-scopes", nil, "[DEPRECATED] List of extra scopes to ask. Use oidc.scopes option instead. Option will be removed in next release.")
+	// Migrated to oidc.scopes, see Deprecations
+	cmd.Flags().StringSlice("oidc-extra-scopes", nil, "List of extra scopes to ask")
+	_ = cmd.Flags().MarkDeprecated("oidc-extra-scopes", "use --oidc-scopes instead")
 	cmd.Flags().StringToString("oidc-extra-authcodeopts", nil, "K/V list of extra authorisation code to include in token request")
 }
 
+// Log is the logging configuration
+type Log struct {
+	Format string
+	Level  string
+	// RedactClaims are the claims redacted from logs,
+	// nested claims are separated by dots (ex: "address.street")
+	RedactClaims []string
+	// RedactQueryParams are the query parameters
+	// redacted from logged urls
+	RedactQueryParams []string
+}
+
+// AddFlags init log flags
+func (l *Log) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("log-format", "json", "Log format: json or text")
+	cmd.Flags().String("log-level", "info", "Log level: debug, info, warning or error")
+	cmd.Flags().StringSlice("log-redactclaims", nil, "Claims redacted from logs, nested claims are separated by dots. Ex: 'email,address.street'")
+	cmd.Flags().StringSlice("log-redactqueryparams", DefaultRedactQueryParams, "Query parameters redacted from logged urls")
+}
+
+// Tracing is the OpenTelemetry tracing configuration
+type Tracing struct {
+	Enabled bool
+	// Exporter is "otlp" (OTLP over HTTP) or "stdout"
+	Exporter string
+	// Endpoint is the OTLP collector "host:port"
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// AddFlags init tracing flags
+func (t *Tracing) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("tracing-enabled", false, "Enable OpenTelemetry tracing")
+	cmd.Flags().String("tracing-exporter", "otlp", "Traces exporter: otlp (OTLP over HTTP) or stdout")
+	cmd.Flags().String("tracing-endpoint", "localhost:4318", "OTLP collector endpoint, 'host:port'")
+	cmd.Flags().Bool("tracing-insecure", false, "Export traces to the OTLP collector without TLS")
+	cmd.Flags().Float64("tracing-sampleratio", 1, "Ratio of traces sampled, when requests have no parent span")
+}
+
+// Audit is the configuration of the audit log of issued
+// credentials. Events are written to every configured sink
+type Audit struct {
+	// Stdout writes events as json lines to stdout
+	Stdout  bool
+	File    AuditFile
+	Webhook AuditWebhook
+}
+
+// AddFlags init audit flags
+func (a *Audit) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("audit-stdout", false, "Write audit events to stdout")
+	a.File.AddFlags(cmd)
+	a.Webhook.AddFlags(cmd)
+}
+
+// Enabled reports if at least one audit sink is configured
+func (a *Audit) Enabled() bool {
+	return a.Stdout || a.File.Path != "" || a.Webhook.URL != ""
+}
+
+// AuditFile is the audit file sink configuration,
+// the file is rotated when it reaches MaxSize
+type AuditFile struct {
+	Path string
+	// MaxSize is the size of the file
+	// in megabytes before rotation
+	MaxSize int
+	// MaxBackups is the number of rotated files kept, MaxAge
+	// the number of days they are kept. 0 keeps everything
+	MaxBackups int
+	MaxAge     int
+}
+
+// AddFlags init audit file flags
+func (af *AuditFile) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("audit-file-path", "", "Write audit events to this file")
+	cmd.Flags().Int("audit-file-maxsize", 100, "Size of the audit file in megabytes before rotation")
+	cmd.Flags().Int("audit-file-maxbackups", 0, "Number of rotated audit files to keep, 0 keeps every file")
+	cmd.Flags().Int("audit-file-maxage", 0, "Number of days to keep rotated audit files, 0 keeps every file")
+}
+
+// AuditWebhook is the audit webhook sink configuration,
+// events are sent as json documents with POST requests
+type AuditWebhook struct {
+	URL     string
+	Timeout time.Duration
+	// Headers are added to webhook requests
+	// (ex: "Authorization: Bearer xxx")
+	Headers map[string]string
+}
+
+// AddFlags init audit webhook flags
+func (aw *AuditWebhook) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("audit-webhook-url", "", "Send audit events to this url")
+	cmd.Flags().Duration("audit-webhook-timeout", 5*time.Second, "Timeout of audit webhook requests")
+	cmd.Flags().StringToString("audit-webhook-headers", nil, "Headers added to audit webhook requests")
+}
+
+// Debug is the configuration of debug endpoints (pprof, effective
+// configuration, provider metadata, version), served by the admin
+// listener: the metrics listener, or a listener on Listen if metrics
+// are not exported on a dedicated listener
+type Debug struct {
+	Enabled bool
+	Listen  string
+}
+
+// AddFlags init debug flags
+func (d *Debug) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("debug-enabled", false, "Enable pprof and debug endpoints on the admin listener")
+	cmd.Flags().String("debug-listen", "127.0.0.1:9091", "Listen interface and port of the admin listener, when metrics are not exported on a dedicated listener")
+}
+
 // Metrics is the exported metrics configuration
 type Metrics struct {
-	Port int
+	Enabled bool
+	// Listen is the "ip:port" of the metrics
+	// listener, "0.0.0.0:<Port>" if empty
+	Listen string
+	Port   int
+	// Path of the metrics endpoint
+	Path string
+	// MainListener serves metrics on the application
+	// listener instead of a dedicated listener
+	MainListener bool
+	TLS          TLS
+	BasicAuth    BasicAuth
+	// Buckets of the request duration histogram, in seconds,
+	// DefaultMetricsBuckets if empty. Changes require a restart
+	Buckets []float64
 }
 
+// DefaultMetricsBuckets are the default buckets
+// of the request duration histogram
+var DefaultMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
 // AddFlags init metrics flags
 func (m *Metrics) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("metrics-enabled", true, "Export metrics")
+	cmd.Flags().String("metrics-listen", "", "Listen interface and port of the metrics listener, overrides metrics.port. Ex: '127.0.0.1:9090'")
 	cmd.Flags().Int("metrics-port", 9090, "Port to export metrics")
+	cmd.Flags().String("metrics-path", "/metrics", "Path of the metrics endpoint")
+	cmd.Flags().Bool("metrics-mainlistener", false, "Export metrics on the application listener instead of a dedicated listener")
+	cmd.Flags().Bool("metrics-tls-enabled", false, "Enable TLS on the metrics listener")
+	cmd.Flags().String("metrics-tls-cert", "", "TLS certificate path of the metrics listener")
+	cmd.Flags().String("metrics-tls-key", "", "TLS private key path of the metrics listener")
+	cmd.Flags().String("metrics-basicauth-username", "", "Username required to access metrics, basic authentication is disabled if empty")
+	cmd.Flags().String("metrics-basicauth-password", "", "Password required to access metrics")
+}
+
+// Address returns the "ip:port" of the metrics listener
+func (m *Metrics) Address() string {
+	if m.Listen != "" {
+		return m.Listen
+	}
+	return fmt.Sprintf("0.0.0.0:%d", m.Port)
+}
+
+// BasicAuth are basic authentication credentials,
+// authentication is disabled if Username is empty
+type BasicAuth struct {
+	Username string
+	Password string
 }
 
 // TLS is the tls configuration, required to configure HTTPS endpoint for Loginapp
@@ -128,15 +314,34 @@ func (t *TLS) AddFlags(cmd *cobra.Command) {
 // Web is the web output configuration, mainly used to customize output
 type Web struct {
 	MainUsernameClaim string
+	UsernameTemplate  string
+	UsernamePrefix    string
+	GroupsClaim       string
 	MainClientID      string
 	TemplatesDir      string
 	AssetsDir         string
 	Kubeconfig        WebKubeconfig
+	Outputs           []WebOutput
+	// Errors is the user guidance displayed
+	// on error pages, per error type
+	Errors map[string]WebError
+	// usernameTemplate is UsernameTemplate,
+	// parsed when the configuration is loaded
+	usernameTemplate *claims.Template
+}
+
+// ParsedUsernameTemplate returns the username template parsed
+// when the configuration is loaded, nil if not loaded or invalid
+func (w *Web) ParsedUsernameTemplate() *claims.Template {
+	return w.usernameTemplate
 }
 
 // AddFlags init web flags
 func (w *Web) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().String("web-mainusernameclaim", "email", "Claim to use for username (depends on IDP available claims")
+	cmd.Flags().String("web-usernametemplate", "", "Go template evaluated against claims to build the username, overrides web.mainUsernameClaim. Ex: '{{ .preferred_username }}@{{ .hd }}'")
+	cmd.Flags().String("web-usernameprefix", "", "Prefix added to the username, should match the '--oidc-username-prefix' flag of the API servers. Ex: 'oidc:'")
+	cmd.Flags().String("web-groupsclaim", "groups", "Claim listing user groups, displayed in the identity output")
 	cmd.Flags().String("web-mainclientid", "", "Application client ID")
 	cmd.Flags().String("web-templatesdir", "/web/templates", "Directory to look for templates, which are overriding embedded")
 	cmd.Flags().String("web-assetsdir", "/web/assets", "Directory to look for assets, which are overriding embedded")
@@ -158,3 +363,49 @@ func (wk *WebKubeconfig) AddFlags(cmd *cobra.Command) {
 	cmd.Flags().String("web-kubeconfig-defaultcontext", "", "Default context to use for full kubeconfig output. Use the following format by default: 'defaultcluster'/'usernameclaim'")
 	cmd.Flags().StringToString("web-kubeconfig-extraopts", nil, "Extra key/value pairs to add to kubeconfig output. Key/value pairs are added under 'user.auth-provider.config' dictionnary into the kubeconfig")
 }
+
+// WebOutput is a custom output tab of the token page,
+// rendered with the template data model
+type WebOutput struct {
+	// Name identifies the output, it must only
+	// contain lower case letters, digits and '-'
+	Name  string
+	Title string
+	// Template is an inline template, TemplateFile a
+	// template file. Only one of them must be set
+	Template     string
+	TemplateFile string
+	// ContentType of the download, default: text/plain
+	ContentType string
+	// Filename enables the download of the output
+	Filename string
+}
+
+// WebError is the user guidance displayed for an error type
+type WebError struct {
+	Help  string
+	Links []WebLink
+}
+
+// WebLink is a link displayed in the web interface
+type WebLink struct {
+	Title string
+	URL   string
+}
+
+// Discovery is the configuration of clusters discovery
+// from kubernetes objects
+type Discovery struct {
+	Enabled       bool
+	Namespace     string
+	LabelSelector string
+	ResyncPeriod  time.Duration
+}
+
+// AddFlags init discovery flags
+func (d *Discovery) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("discovery-enabled", false, "Discover clusters from labelled ConfigMaps and Secrets. Loginapp must run inside kubernetes")
+	cmd.Flags().String("discovery-namespace", "", "Namespace to look for clusters. Defaults to loginapp namespace")
+	cmd.Flags().String("discovery-labelselector", "loginapp.fydrah.com/cluster=true", "Label selector of ConfigMaps and Secrets describing clusters")
+	cmd.Flags().Duration("discovery-resyncperiod", 10*time.Minute, "Interval between two full listings of discovered clusters")
+}
@@ -19,9 +19,11 @@ import (
 )
 
 // Check is a configuration check
-// used by check function
+// used by check function. Path is the
+// configuration key checked (ex: "oidc.client.id")
 type Check struct {
 	FailedCondition bool
+	Path            string
 	Message         string
 	DefaultAction   func()
 }
@@ -14,18 +14,111 @@
 
 package config
 
-import "encoding/base64"
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/kube"
+	"github.com/spf13/viper"
+)
+
+// DefaultCARefreshInterval is the default interval between
+// two discoveries of a cluster certificate authority
+const DefaultCARefreshInterval = time.Hour
+
+var caCertHashFormat = regexp.MustCompile(`^sha256:[0-9a-fA-F]{64}$`)
 
 // Cluster describes a Kubernetes cluster
 type Cluster struct {
+	// Kubeconfig is a kubeconfig file or directory, the entry is
+	// replaced by the clusters imported from it during configuration
+	// init. ContextFilter is a regular expression filtering contexts
+	Kubeconfig            string
+	ContextFilter         string
 	Name                  string
 	Server                string
 	InsecureSkipTLSVerify bool   `mapstructure:"insecure-skip-tls-verify"`
 	CertificateAuthority  string `mapstructure:"certificate-authority"`
-	ContextName           string
+	// CertificateAuthorityFile is loaded into
+	// CertificateAuthority during configuration init
+	CertificateAuthorityFile string `mapstructure:"certificate-authority-file"`
+	// DiscoverCA enables the certificate authority discovery
+	// from the kube-public/cluster-info configmap, the discovered
+	// authority must match CACertHash
+	DiscoverCA         bool
+	CACertHash         string
+	CARefreshInterval  time.Duration
+	TLSServerName      string `mapstructure:"tls-server-name"`
+	ProxyURL           string `mapstructure:"proxy-url"`
+	DisableCompression bool   `mapstructure:"disable-compression"`
+	Extensions         map[string]interface{}
+	// ContextName is a claims template, the
+	// context name is "<name>/<username>" if empty
+	ContextName string
+	// DefaultNamespace is a claims template, overriding
+	// web.kubeconfig.defaultNamespace for this cluster
+	DefaultNamespace string
+	RBACPreview      ClusterRBACPreview
+}
+
+// ClusterRBACPreview configures the preview of user permissions,
+// performed with the user ID token against the cluster API server
+type ClusterRBACPreview struct {
+	Enabled    bool
+	Namespaces []string
+	Timeout    time.Duration
 }
 
 // Base64Cert convert a plain text certificate to a base64 encoded string
 func (c *Cluster) Base64Cert() string {
 	return base64.StdEncoding.EncodeToString([]byte(c.CertificateAuthority))
 }
+
+// DefaultContextName returns the kubeconfig context name of
+// the cluster for username, used when ContextName is not set
+func (c *Cluster) DefaultContextName(username string) string {
+	return fmt.Sprintf("%s/%s", c.Name, username)
+}
+
+// LoadCertificateAuthority loads the certificate authority
+// file if any, and validates the certificate authority
+func (c *Cluster) LoadCertificateAuthority() error {
+	if c.CertificateAuthorityFile != "" {
+		if c.CertificateAuthority != "" {
+			return fmt.Errorf("certificate-authority and certificate-authority-file are mutually exclusive")
+		}
+		ca, err := ioutil.ReadFile(c.CertificateAuthorityFile)
+		if err != nil {
+			return fmt.Errorf("failed to read certificate-authority-file: %v", err)
+		}
+		c.CertificateAuthority = string(ca)
+	}
+	if c.CertificateAuthority != "" {
+		if _, err := kube.ParseCertificates(c.CertificateAuthority); err != nil {
+			return fmt.Errorf("invalid certificate-authority: %v", err)
+		}
+	}
+	if c.DiscoverCA && !caCertHashFormat.MatchString(c.CACertHash) {
+		return fmt.Errorf("discoverCA requires a caCertHash with format 'sha256:<hex encoded public key hash>'")
+	}
+	return nil
+}
+
+// DecodeCluster decodes a yaml document describing a single
+// cluster, with the same format than the clusters list
+func DecodeCluster(data []byte) (Cluster, error) {
+	var c Cluster
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return c, err
+	}
+	if err := v.Unmarshal(&c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
@@ -18,9 +18,23 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/fydrah/loginapp/pkg/claims"
+	"github.com/fydrah/loginapp/pkg/logging"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+)
+
+var (
+	// outputNameFormat is the format of output names,
+	// used as html element ids
+	outputNameFormat = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	// metricsPathFormat is the format of the metrics path
+	metricsPathFormat = regexp.MustCompile(`^(/[a-zA-Z0-9._-]+)+$`)
 )
 
 // Init load configuration,
@@ -30,25 +44,32 @@ func (a *App) Init() error {
 		Extract data from yaml configuration file
 	*/
 
-	if err := viper.Unmarshal(&a); err != nil {
+	settings, deprecations, err := a.load()
+	if err != nil {
 		return err
 	}
 
-	/*
-		Error checks: list of checks which make loginapp failed
-	*/
-	errorChecks := []Check{
-		{a.Name == "", "no name specified", nil},
-		{a.Listen == "", "no listen 'ip:port' specified", nil},
-		{a.OIDC.Client.ID == "", "no oidc.client.id specified", nil},
-		{a.OIDC.Client.Secret == "", "no oidc.client.secret specified", nil},
-		{a.OIDC.Client.RedirectURL == "", "no oidc.client.redirectURL specified", nil},
-		{a.OIDC.Issuer.URL == "", "no oidc.issuer.url specified", nil},
-		{!a.OIDC.Issuer.InsecureSkipVerify && a.OIDC.Issuer.RootCA == "", "no oidc.issuer.rootCA specified", nil},
-		{a.TLS.Enabled && a.TLS.Cert == "", "no tls.cert specified", nil},
-		{a.TLS.Enabled && a.TLS.Key == "", "no tls.key specified", nil},
+	// Configure logging first, so that
+	// checks below use the configured logger.
+	// Errors are reported by error checks
+	_ = a.Log.Apply()
+
+	if len(deprecations) > 0 {
+		keys := make(map[string]string, len(deprecations))
+		for _, d := range deprecations {
+			keys[d.Key] = d.NewKey
+		}
+		log.WithField("deprecated_keys", keys).Warning("deprecated configuration keys migrated, run 'loginapp config migrate' to update the configuration file")
 	}
 
+	// Unknown keys are errors in strict mode, warnings otherwise
+	errorChecks := append(a.LoadChecks(), a.ErrorChecks()...)
+	var keyWarnings []Check
+	if a.strict {
+		errorChecks = append(errorChecks, unknownKeyChecks(settings)...)
+	} else {
+		keyWarnings = unknownKeyChecks(settings)
+	}
 	if configCheck(errorChecks) {
 		return fmt.Errorf("error while loading configuration")
 	}
@@ -60,33 +81,168 @@ func (a *App) Init() error {
 		This issue could help to use loglevel as a parameter once merged:
 		https://github.com/sirupsen/logrus/issues/646
 	*/
+	if ok := configCheck(append(a.DefaultChecks(), keyWarnings...)); !ok {
+		log.Info("Non-blocking configuration missing, using defaults")
+	}
+
+	log.Debugf("Configuration loaded: %+v", a.Redacted(logging.Redacted))
+
+	return nil
+}
+
+// LoadChecks imports clusters from kubeconfig files, loads
+// certificate authority files and parses the username template.
+// It returns the checks of these steps, which make loginapp
+// failed. It must run once per loaded configuration, before
+// ErrorChecks
+func (a *App) LoadChecks() []Check {
+	importErr := a.importClusters()
+	var usernameTmplErr error
+	a.Web.usernameTemplate, usernameTmplErr = claims.NewTemplate("username", a.Web.UsernameTemplate)
+	loadChecks := []Check{
+		{importErr != nil, "clusters", fmt.Sprintf("failed to import clusters: %v", importErr), nil},
+		{usernameTmplErr != nil, "web.usernameTemplate", fmt.Sprintf("invalid web.usernameTemplate: %v", usernameTmplErr), nil},
+	}
+	for i := range a.Clusters {
+		caErr := a.Clusters[i].LoadCertificateAuthority()
+		loadChecks = append(loadChecks,
+			Check{caErr != nil, fmt.Sprintf("clusters[%d].certificate-authority", i), fmt.Sprintf("invalid clusters[%d] certificate authority: %v", i, caErr), nil},
+		)
+	}
+	return loadChecks
+}
+
+// ErrorChecks returns the list of checks which make
+// loginapp failed. The configuration is not modified
+func (a *App) ErrorChecks() []Check {
+	_, _, logErr := a.Log.parse()
+	webhookURL, webhookErr := url.Parse(a.Audit.Webhook.URL)
+	_, namespaceTmplErr := claims.NewTemplate("namespace", a.Web.Kubeconfig.DefaultNamespace)
+
+	errorChecks := []Check{
+		{a.Version != "" && !supportedVersion(a.Version), "version", fmt.Sprintf("unsupported version %q, supported versions: %s", a.Version, strings.Join(Versions, ", ")), nil},
+		{a.Name == "", "name", "no name specified", nil},
+		{a.Listen == "", "listen", "no listen 'ip:port' specified", nil},
+		{a.OIDC.Client.ID == "", "oidc.client.id", "no oidc.client.id specified", nil},
+		{a.OIDC.Client.Secret == "", "oidc.client.secret", "no oidc.client.secret specified", nil},
+		{a.OIDC.Client.RedirectURL == "", "oidc.client.redirectURL", "no oidc.client.redirectURL specified", nil},
+		{a.OIDC.Issuer.URL == "", "oidc.issuer.url", "no oidc.issuer.url specified", nil},
+		{!a.OIDC.Issuer.InsecureSkipVerify && a.OIDC.Issuer.RootCA == "", "oidc.issuer.rootCA", "no oidc.issuer.rootCA specified", nil},
+		{a.TLS.Enabled && a.TLS.Cert == "", "tls.cert", "no tls.cert specified", nil},
+		{a.TLS.Enabled && a.TLS.Key == "", "tls.key", "no tls.key specified", nil},
+		{!increasing(a.Metrics.Buckets), "metrics.buckets", "metrics.buckets must be in increasing order", nil},
+		{a.Metrics.Enabled && !metricsPathFormat.MatchString(a.Metrics.Path), "metrics.path", fmt.Sprintf("invalid metrics.path %q, must match %s", a.Metrics.Path, metricsPathFormat), nil},
+		{a.Metrics.Enabled && a.Metrics.MainListener && reservedPath(a.Metrics.Path), "metrics.path", fmt.Sprintf("metrics.path %q conflicts with application routes", a.Metrics.Path), nil},
+		{a.Metrics.TLS.Enabled && !a.Metrics.MainListener && a.Metrics.TLS.Cert == "", "metrics.tls.cert", "no metrics.tls.cert specified", nil},
+		{a.Metrics.TLS.Enabled && !a.Metrics.MainListener && a.Metrics.TLS.Key == "", "metrics.tls.key", "no metrics.tls.key specified", nil},
+		{a.Metrics.BasicAuth.Username != "" && a.Metrics.BasicAuth.Password == "", "metrics.basicAuth.password", "no metrics.basicAuth.password specified", nil},
+		{a.Debug.Enabled && a.Metrics.Enabled && (a.Metrics.Path == "/version" || strings.HasPrefix(a.Metrics.Path, "/debug/")), "metrics.path", fmt.Sprintf("metrics.path %q conflicts with debug routes", a.Metrics.Path), nil},
+		{a.Debug.Enabled && (!a.Metrics.Enabled || a.Metrics.MainListener) && a.Debug.Listen == "", "debug.listen", "no debug.listen specified", nil},
+		{a.Debug.Enabled && a.Metrics.BasicAuth.Username == "" && a.adminAddress() != "" && !loopback(a.adminAddress()), "debug.enabled", fmt.Sprintf("debug endpoints would be served without authentication on %s, set metrics.basicAuth or a loopback listen address", a.adminAddress()), nil},
+		{a.Tracing.Enabled && a.Tracing.Exporter != "otlp" && a.Tracing.Exporter != "stdout", "tracing.exporter", fmt.Sprintf("invalid tracing.exporter %q, must be otlp or stdout", a.Tracing.Exporter), nil},
+		{a.Tracing.SampleRatio < 0 || a.Tracing.SampleRatio > 1, "tracing.sampleRatio", "tracing.sampleRatio must be between 0 and 1", nil},
+		{a.Audit.Webhook.URL != "" && (webhookErr != nil || webhookURL.Host == "" || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https")), "audit.webhook.url", fmt.Sprintf("invalid audit.webhook.url %q", a.Audit.Webhook.URL), nil},
+		{a.Audit.File.MaxSize < 0 || a.Audit.File.MaxBackups < 0 || a.Audit.File.MaxAge < 0, "audit.file", "audit.file.maxSize, maxBackups and maxAge must not be negative", nil},
+		{logErr != nil, "log", fmt.Sprintf("invalid log configuration: %v", logErr), nil},
+		{namespaceTmplErr != nil, "web.kubeconfig.defaultNamespace", fmt.Sprintf("invalid web.kubeconfig.defaultNamespace: %v", namespaceTmplErr), nil},
+	}
+	for i, c := range a.Clusters {
+		_, nsErr := claims.NewTemplate("namespace", c.DefaultNamespace)
+		_, contextErr := claims.NewTemplate("context", c.ContextName)
+		errorChecks = append(errorChecks,
+			Check{c.Name == "", fmt.Sprintf("clusters[%d].name", i), fmt.Sprintf("no clusters[%d].name specified", i), nil},
+			Check{nsErr != nil, fmt.Sprintf("clusters[%d].defaultNamespace", i), fmt.Sprintf("invalid clusters[%d].defaultNamespace: %v", i, nsErr), nil},
+			Check{contextErr != nil, fmt.Sprintf("clusters[%d].contextName", i), fmt.Sprintf("invalid clusters[%d].contextName: %v", i, contextErr), nil},
+		)
+	}
+
+	for i, o := range a.Web.Outputs {
+		errorChecks = append(errorChecks,
+			Check{!outputNameFormat.MatchString(o.Name), fmt.Sprintf("web.outputs[%d].name", i), fmt.Sprintf("invalid web.outputs[%d].name %q, must match %s", i, o.Name, outputNameFormat), nil},
+			Check{o.Title == "", fmt.Sprintf("web.outputs[%d].title", i), fmt.Sprintf("no web.outputs[%d].title specified", i), nil},
+			Check{(o.Template == "") == (o.TemplateFile == ""), fmt.Sprintf("web.outputs[%d].template", i), fmt.Sprintf("one of web.outputs[%d].template or web.outputs[%d].templateFile must be specified", i, i), nil},
+		)
+		for _, other := range a.Web.Outputs[:i] {
+			errorChecks = append(errorChecks,
+				Check{o.Name == other.Name, fmt.Sprintf("web.outputs[%d].name", i), fmt.Sprintf("duplicate web.outputs[%d].name %q", i, o.Name), nil},
+			)
+		}
+	}
+	return errorChecks
+}
+
+// DefaultChecks returns the list of checks which makes loginapp
+// setup default values, or warn about insecure settings
+func (a *App) DefaultChecks() []Check {
 	defaultChecks := []Check{
-		{a.Secret == "", "no secret defined, using a random secret but it is strongly advised to add a secret since without it requests cannot be load balanced between multiple server", func() {
+		{a.Version == "", "version", fmt.Sprintf("no version specified, using default: %s", CurrentVersion), func() {
+			a.Version = CurrentVersion
+		}},
+		{a.Secret == "", "secret", "no secret defined, using a random secret but it is strongly advised to add a secret since without it requests cannot be load balanced between multiple server", func() {
 			a.Secret = randomString()
 		}},
-		{a.Web.MainClientID == "", fmt.Sprintf("no output web.mainClientID specified, using default: %v", a.OIDC.Client.ID), func() {
+		{a.Web.MainClientID == "", "web.mainClientID", fmt.Sprintf("no output web.mainClientID specified, using default: %v", a.OIDC.Client.ID), func() {
 			a.Web.MainClientID = a.OIDC.Client.ID
 		}},
-		{a.Web.MainUsernameClaim == "", "no output web.mainUsernameClaim specified, using default: 'name'", func() {
+		{a.Web.MainUsernameClaim == "", "web.mainUsernameClaim", "no output web.mainUsernameClaim specified, using default: 'name'", func() {
 			a.Web.MainUsernameClaim = "name"
 		}},
-		{len(a.Clusters) > 0 && a.Web.Kubeconfig.DefaultCluster == "", "No default cluster name for kubeconfig context, using first cluster name available, ", func() {
+		{a.Web.GroupsClaim == "", "web.groupsClaim", "no output web.groupsClaim specified, using default: 'groups'", func() {
+			a.Web.GroupsClaim = "groups"
+		}},
+		{len(a.Clusters) > 0 && a.Web.Kubeconfig.DefaultCluster == "", "web.kubeconfig.defaultCluster", "No default cluster name for kubeconfig context, using first cluster name available, ", func() {
 			a.Web.Kubeconfig.DefaultCluster = a.Clusters[0].Name
 		}},
-		{len(a.Clusters) == 0 && a.Web.Kubeconfig.DefaultCluster == "", "No cluster defined, setting default cluster output to none", func() {
+		{len(a.Clusters) == 0 && a.Web.Kubeconfig.DefaultCluster == "", "web.kubeconfig.defaultCluster", "No cluster defined, setting default cluster output to none", func() {
 			a.Web.Kubeconfig.DefaultCluster = "none"
 		}},
-		{a.Metrics.Port == 0, "no metrics.port setup, using default: 9090", func() {
+		{a.Metrics.Enabled && !a.Metrics.MainListener && a.Metrics.Listen == "" && a.Metrics.Port == 0, "metrics.port", "no metrics.listen or metrics.port setup, using default: 0.0.0.0:9090", func() {
 			a.Metrics.Port = 9090
 		}},
-		{a.OIDC.Issuer.InsecureSkipVerify, "Certificate validation is currently disabled, this is not a recommended behavior for production", nil},
+		{a.Metrics.Enabled && a.Metrics.MainListener && a.Metrics.TLS.Enabled, "metrics.tls", "metrics.tls is ignored when metrics are exported on the application listener", nil},
+		{a.Discovery.Enabled && a.Discovery.LabelSelector == "", "discovery.labelSelector", "no discovery.labelSelector specified, using default: loginapp.fydrah.com/cluster=true", func() {
+			a.Discovery.LabelSelector = "loginapp.fydrah.com/cluster=true"
+		}},
+		{a.Discovery.Enabled && a.Discovery.ResyncPeriod <= 0, "discovery.resyncPeriod", "no discovery.resyncPeriod specified, using default: 10m", func() {
+			a.Discovery.ResyncPeriod = 10 * time.Minute
+		}},
+		{a.Audit.Webhook.URL != "" && a.Audit.Webhook.Timeout <= 0, "audit.webhook.timeout", "no audit.webhook.timeout specified, using default: 5s", func() {
+			a.Audit.Webhook.Timeout = 5 * time.Second
+		}},
+		{a.Audit.File.Path != "" && a.Audit.File.MaxSize == 0, "audit.file.maxSize", "no audit.file.maxSize specified, using default: 100", func() {
+			a.Audit.File.MaxSize = 100
+		}},
+		{a.Debug.Enabled, "debug.enabled", "Debug endpoints are enabled, they should only be reachable by administrators", nil},
+		{a.OIDC.Issuer.InsecureSkipVerify, "oidc.issuer.insecureSkipVerify", "Certificate validation is currently disabled, this is not a recommended behavior for production", nil},
 	}
-	if ok := configCheck(defaultChecks); !ok {
-		log.Info("Non-blocking configuration missing, using defaults")
+	for i := range a.Web.Outputs {
+		o := &a.Web.Outputs[i]
+		defaultChecks = append(defaultChecks,
+			Check{o.ContentType == "", fmt.Sprintf("web.outputs[%d].contentType", i), fmt.Sprintf("no web.outputs[%d].contentType specified, using default: text/plain", i), func() {
+				o.ContentType = "text/plain"
+			}},
+		)
 	}
+	return defaultChecks
+}
 
-	log.Debugf("Configuration loaded: %+v", a)
-
+// importClusters replaces cluster entries with a kubeconfig
+// by the clusters imported from this kubeconfig
+func (a *App) importClusters() error {
+	var clusters []Cluster
+	for _, c := range a.Clusters {
+		if c.Kubeconfig == "" {
+			clusters = append(clusters, c)
+			continue
+		}
+		imported, err := ImportClusters(c.Kubeconfig, c.ContextFilter, c)
+		if err != nil {
+			return err
+		}
+		log.Debugf("%d clusters imported from %q", len(imported), c.Kubeconfig)
+		clusters = append(clusters, imported...)
+	}
+	a.Clusters = clusters
 	return nil
 }
 
@@ -100,6 +256,50 @@ func configCheck(checks []Check) bool {
 	return checkFailed
 }
 
+// increasing reports if values are in strictly increasing order
+func increasing(values []float64) bool {
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// adminAddress returns the "ip:port" of the admin listener:
+// the metrics listener if any, debug.listen otherwise
+func (a *App) adminAddress() string {
+	if a.Metrics.Enabled && !a.Metrics.MainListener {
+		return a.Metrics.Address()
+	}
+	return a.Debug.Listen
+}
+
+// loopback reports if the host of address
+// is a loopback address or "localhost"
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// reservedPath reports if path conflicts with
+// the routes of the application listener
+func reservedPath(path string) bool {
+	for _, p := range []string{"/callback", "/kubeconfig", "/healthz"} {
+		if path == p {
+			return true
+		}
+	}
+	return path == "/assets" || strings.HasPrefix(path, "/assets/")
+}
+
 func randomString() string {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
@@ -0,0 +1,143 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA returns a pem encoded self-signed CA certificate
+func testCA(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// failed returns the failed checks with path
+func failed(checks []Check, path string) []Check {
+	var f []Check
+	for _, c := range checks {
+		if c.FailedCondition && c.Path == path {
+			f = append(f, c)
+		}
+	}
+	return f
+}
+
+func TestDebugAuthentication(t *testing.T) {
+	auth := BasicAuth{Username: "admin", Password: "secret"}
+	tests := []struct {
+		name    string
+		debug   Debug
+		metrics Metrics
+		refused bool
+	}{
+		{"disabled", Debug{}, Metrics{Enabled: true, Port: 9090}, false},
+		{"metrics listener", Debug{Enabled: true}, Metrics{Enabled: true, Port: 9090}, true},
+		{"metrics listener with basic auth", Debug{Enabled: true}, Metrics{Enabled: true, Port: 9090, BasicAuth: auth}, false},
+		{"loopback metrics listener", Debug{Enabled: true}, Metrics{Enabled: true, Listen: "127.0.0.1:9090"}, false},
+		{"default debug listener", Debug{Enabled: true, Listen: "127.0.0.1:9091"}, Metrics{}, false},
+		{"localhost debug listener", Debug{Enabled: true, Listen: "localhost:9091"}, Metrics{Enabled: true, MainListener: true}, false},
+		{"ipv6 loopback debug listener", Debug{Enabled: true, Listen: "[::1]:9091"}, Metrics{}, false},
+		{"public debug listener", Debug{Enabled: true, Listen: "0.0.0.0:9091"}, Metrics{}, true},
+		{"public debug listener with basic auth", Debug{Enabled: true, Listen: ":9091"}, Metrics{BasicAuth: auth}, false},
+	}
+	for _, tt := range tests {
+		a := &App{Debug: tt.debug, Metrics: tt.metrics}
+		if refused := len(failed(a.ErrorChecks(), "debug.enabled")) > 0; refused != tt.refused {
+			t.Errorf("%s: got refused %t, want %t", tt.name, refused, tt.refused)
+		}
+	}
+}
+
+func TestLoadChecks(t *testing.T) {
+	dir := writeKubeconfigs(t, map[string]string{
+		"prod.yaml": fmt.Sprintf(kubeadmKubeconfig, "https://prod:6443"),
+	})
+	defer os.RemoveAll(dir)
+	ca := testCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(caFile, []byte(ca), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		Clusters: []Cluster{
+			{Name: "imported", Kubeconfig: dir},
+			{Name: "local", Server: "https://local:6443", CertificateAuthorityFile: caFile},
+		},
+		Web: Web{UsernameTemplate: "oidc:{{ .email }}"},
+	}
+	clusters := append([]Cluster(nil), a.Clusters...)
+
+	// Error checks do not modify the configuration
+	for i := 0; i < 2; i++ {
+		a.ErrorChecks()
+		if !reflect.DeepEqual(a.Clusters, clusters) || a.Web.ParsedUsernameTemplate() != nil {
+			t.Fatalf("error checks modified the configuration: %+v", a)
+		}
+	}
+
+	for _, c := range a.LoadChecks() {
+		if c.FailedCondition {
+			t.Errorf("%s: %s", c.Path, c.Message)
+		}
+	}
+	if got, want := clusterNames(a.Clusters), map[string]string{"kubernetes": "https://prod:6443", "local": "https://local:6443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got clusters %v, want %v", got, want)
+	}
+	if len(a.Clusters) != 2 || a.Clusters[1].CertificateAuthority != ca {
+		t.Errorf("certificate authority file not loaded: %+v", a.Clusters)
+	}
+	if a.Web.ParsedUsernameTemplate() == nil {
+		t.Error("username template not parsed")
+	}
+	for _, c := range a.ErrorChecks() {
+		if c.FailedCondition && strings.HasPrefix(c.Path, "clusters") {
+			t.Errorf("%s: %s", c.Path, c.Message)
+		}
+	}
+	if len(a.Clusters) != 2 {
+		t.Errorf("error checks imported clusters again: %+v", a.Clusters)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Deprecation is a deprecated configuration key, replaced by NewKey
+type Deprecation struct {
+	Key    string
+	NewKey string
+	// Migrate returns the value of NewKey from the value of Key
+	// and the value of NewKey, nil if not set. The value of Key
+	// is moved to NewKey if Migrate is nil
+	Migrate func(value interface{}, newValue interface{}) interface{}
+}
+
+// Deprecations are the deprecated configuration keys,
+// migrated when the configuration is loaded
+var Deprecations = []Deprecation{
+	// Extra scopes are added to oidc.scopes, or to the default scopes
+	{Key: "oidc.extra.scopes", NewKey: "oidc.scopes", Migrate: func(value interface{}, newValue interface{}) interface{} {
+		scopes := DefaultScopes
+		if newValue != nil {
+			scopes = toStrings(newValue)
+		}
+		return appendUnique(scopes, toStrings(value)...)
+	}},
+}
+
+func (d Deprecation) migrate(value interface{}, newValue interface{}) interface{} {
+	if d.Migrate == nil {
+		return value
+	}
+	return d.Migrate(value, newValue)
+}
+
+// load decodes the configuration loaded by viper into a, with
+// deprecated keys migrated. The configuration settings and the
+// deprecations applied are returned
+func (a *App) load() (map[string]interface{}, []Deprecation, error) {
+	settings := viper.AllSettings()
+	deprecations := MigrateSettings(settings)
+	v := viper.New()
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, nil, err
+	}
+	if err := v.Unmarshal(a); err != nil {
+		return nil, nil, err
+	}
+	return settings, deprecations, nil
+}
+
+// MigrateSettings migrates deprecated keys of settings, with
+// lower case keys as returned by viper. Deprecations applied
+// to non empty values are returned
+func MigrateSettings(settings map[string]interface{}) []Deprecation {
+	var applied []Deprecation
+	for _, d := range Deprecations {
+		value, ok := mapGet(settings, strings.ToLower(d.Key))
+		if !ok {
+			continue
+		}
+		mapDelete(settings, strings.ToLower(d.Key))
+		if isEmpty(value) {
+			continue
+		}
+		newValue, _ := mapGet(settings, strings.ToLower(d.NewKey))
+		mapSet(settings, strings.ToLower(d.NewKey), d.migrate(value, newValue))
+		applied = append(applied, d)
+	}
+	return applied
+}
+
+// MigrateFile rewrites a yaml configuration file into the current
+// format: deprecated keys are migrated and the version is set to
+// CurrentVersion. Comments are kept. Deprecations applied are returned
+func MigrateFile(data []byte) ([]byte, []Deprecation, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("configuration is not a yaml mapping")
+	}
+	var applied []Deprecation
+	for _, d := range Deprecations {
+		node := nodeGet(root, d.Key)
+		if node == nil {
+			continue
+		}
+		var value, newValue interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode %s: %v", d.Key, err)
+		}
+		if newNode := nodeGet(root, d.NewKey); newNode != nil {
+			if err := newNode.Decode(&newValue); err != nil {
+				return nil, nil, fmt.Errorf("failed to decode %s: %v", d.NewKey, err)
+			}
+		}
+		nodeDelete(root, d.Key)
+		if isEmpty(value) {
+			continue
+		}
+		var migrated yaml.Node
+		if err := migrated.Encode(d.migrate(value, newValue)); err != nil {
+			return nil, nil, err
+		}
+		nodeSet(root, d.NewKey, &migrated)
+		applied = append(applied, d)
+	}
+	version := nodeGet(root, "version")
+	if version == nil {
+		// Version is the first key, below the file header comment
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: "version"}
+		if len(root.Content) > 0 {
+			key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+		root.Content = append([]*yaml.Node{key, {Kind: yaml.ScalarNode, Value: CurrentVersion}}, root.Content...)
+	} else {
+		*version = yaml.Node{Kind: yaml.ScalarNode, Value: CurrentVersion}
+	}
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, err
+	}
+	return b.Bytes(), applied, nil
+}
+
+// mapGet returns the value of a dotted key in nested maps
+func mapGet(m map[string]interface{}, key string) (interface{}, bool) {
+	segments := strings.Split(key, ".")
+	for _, s := range segments[:len(segments)-1] {
+		var ok bool
+		if m, ok = stringMap(m[s]); !ok {
+			return nil, false
+		}
+	}
+	v, ok := m[segments[len(segments)-1]]
+	return v, ok
+}
+
+// mapSet sets the value of a dotted key in nested maps,
+// creating missing maps
+func mapSet(m map[string]interface{}, key string, value interface{}) {
+	segments := strings.Split(key, ".")
+	for _, s := range segments[:len(segments)-1] {
+		child, ok := m[s].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[s] = child
+		}
+		m = child
+	}
+	m[segments[len(segments)-1]] = value
+}
+
+// mapDelete deletes a dotted key from nested maps
+func mapDelete(m map[string]interface{}, key string) {
+	segments := strings.Split(key, ".")
+	for _, s := range segments[:len(segments)-1] {
+		var ok bool
+		if m, ok = m[s].(map[string]interface{}); !ok {
+			return
+		}
+	}
+	delete(m, segments[len(segments)-1])
+}
+
+// nodeIndex returns the mapping node holding a dotted
+// key, and the index of the key node. Keys are case
+// insensitive
+func nodeIndex(root *yaml.Node, key string) (*yaml.Node, int) {
+	node := root
+	segments := strings.Split(key, ".")
+	for i, s := range segments {
+		if node.Kind != yaml.MappingNode {
+			return nil, -1
+		}
+		found := -1
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if strings.EqualFold(node.Content[j].Value, s) {
+				found = j
+				break
+			}
+		}
+		if found < 0 {
+			return nil, -1
+		}
+		if i == len(segments)-1 {
+			return node, found
+		}
+		node = node.Content[found+1]
+	}
+	return nil, -1
+}
+
+// nodeGet returns the value node of a dotted key, nil if not found
+func nodeGet(root *yaml.Node, key string) *yaml.Node {
+	if parent, i := nodeIndex(root, key); parent != nil {
+		return parent.Content[i+1]
+	}
+	return nil
+}
+
+// nodeSet sets the value node of a dotted key,
+// creating missing mappings
+func nodeSet(root *yaml.Node, key string, value *yaml.Node) {
+	if parent, i := nodeIndex(root, key); parent != nil {
+		parent.Content[i+1] = value
+		return
+	}
+	node := root
+	segments := strings.Split(key, ".")
+	for _, s := range segments[:len(segments)-1] {
+		child := nodeGet(node, s)
+		if child == nil || child.Kind != yaml.MappingNode {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			nodeSet(node, s, child)
+		}
+		node = child
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: segments[len(segments)-1]}, value)
+}
+
+// nodeDelete deletes a dotted key, and
+// the mappings it leaves empty
+func nodeDelete(root *yaml.Node, key string) {
+	parent, i := nodeIndex(root, key)
+	if parent == nil {
+		return
+	}
+	parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+	if len(parent.Content) == 0 {
+		if j := strings.LastIndex(key, "."); j > 0 {
+			nodeDelete(root, key[:j])
+		}
+	}
+}
+
+// isEmpty reports if a configuration value is not set
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return rv.Len() == 0
+	}
+	return false
+}
+
+func toStrings(v interface{}) []string {
+	var l []string
+	switch t := v.(type) {
+	case []string:
+		return t
+	case []interface{}:
+		for _, e := range t {
+			l = append(l, fmt.Sprint(e))
+		}
+	case string:
+		// Comma separated list, as environment vars
+		for _, e := range strings.Split(t, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				l = append(l, e)
+			}
+		}
+	}
+	return l
+}
+
+func appendUnique(l []string, values ...string) []string {
+	result := append([]string{}, l...)
+	for _, v := range values {
+		found := false
+		for _, e := range result {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateFile(t *testing.T) {
+	// Fixtures are testdata/migrate/<name>.before.yaml files,
+	// migrated to <name>.after.yaml
+	tests := map[string][]string{
+		// oidc.extra.scopes moves to oidc.scopes, appended to the
+		// default scopes. Comments and other keys are kept
+		"extra-scopes": {"oidc.extra.scopes"},
+		// Scopes of both keys are merged, without duplicates
+		"both-keys": {"oidc.extra.scopes"},
+		// Mappings left empty are deleted
+		"empty-maps": {"oidc.extra.scopes"},
+		// Empty deprecated keys are deleted, not migrated
+		"empty-value": nil,
+		// Current configurations are not modified
+		"current": nil,
+	}
+	for name, want := range tests {
+		before, err := ioutil.ReadFile(filepath.Join("testdata", "migrate", name+".before.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := ioutil.ReadFile(filepath.Join("testdata", "migrate", name+".after.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, applied, err := MigrateFile(before)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(got) != string(after) {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, got, after)
+		}
+		var keys []string
+		for _, d := range applied {
+			keys = append(keys, d.Key)
+		}
+		if strings.Join(keys, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: got deprecations %v, want %v", name, keys, want)
+		}
+
+		// Migrated files are up to date
+		again, applied, err := MigrateFile(after)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(again) != string(after) || len(applied) > 0 {
+			t.Errorf("%s: migrated file is not up to date, got %v\n%s", name, applied, again)
+		}
+	}
+}
+
+func TestMigrateSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"oidc": map[string]interface{}{
+			"scopes": []interface{}{"openid", "email"},
+			"extra":  map[string]interface{}{"scopes": "email, groups"},
+		},
+	}
+	applied := MigrateSettings(settings)
+	if len(applied) != 1 || applied[0].NewKey != "oidc.scopes" {
+		t.Fatalf("got deprecations %v", applied)
+	}
+	scopes, _ := mapGet(settings, "oidc.scopes")
+	if got := strings.Join(toStrings(scopes), ","); got != "openid,email,groups" {
+		t.Errorf("got scopes %s", got)
+	}
+	if _, ok := mapGet(settings, "oidc.extra.scopes"); ok {
+		t.Error("oidc.extra.scopes is not deleted")
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Key returns the configuration key of a struct field: the
+// mapstructure tag if any, the lower camel case field name
+// otherwise (ex: "RBACPreview" -> "rbacPreview")
+func Key(f reflect.StructField) string {
+	if tag := f.Tag.Get("mapstructure"); tag != "" {
+		return tag
+	}
+	r := []rune(f.Name)
+	for i := range r {
+		// Keep the last upper case letter of an acronym
+		// when it starts a new word ("CARefresh" -> "caRefresh")
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		if !unicode.IsUpper(r[i]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// MarshalYAML returns the yaml representation of a configuration
+// value, using configuration keys (see Key). Zero values are omitted
+// if omitEmpty is true
+func MarshalYAML(v interface{}, omitEmpty bool) ([]byte, error) {
+	return yaml.Marshal(toYAMLValue(reflect.ValueOf(v), omitEmpty))
+}
+
+func toYAMLValue(v reflect.Value, omitEmpty bool) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return toYAMLValue(v.Elem(), omitEmpty)
+	case reflect.Struct:
+		var m yaml.MapSlice
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" || (omitEmpty && v.Field(i).IsZero()) {
+				continue
+			}
+			m = append(m, yaml.MapItem{Key: Key(f), Value: toYAMLValue(v.Field(i), omitEmpty)})
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = toYAMLValue(v.Index(i), omitEmpty)
+		}
+		return l
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[interface{}]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().Interface()] = toYAMLValue(iter.Value(), omitEmpty)
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
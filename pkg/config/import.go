@@ -0,0 +1,170 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fydrah/loginapp/pkg/kube"
+)
+
+// ImportClusters returns the clusters referenced by contexts of the
+// kubeconfig file, or of every kubeconfig file of the directory, at
+// path. Only contexts whose name matches contextFilter are imported
+// (every context if empty). User credentials are never imported.
+// Cluster names used in several files are prefixed by the file name.
+//
+// Imported clusters use base as template: every option of base
+// is kept, except cluster name, server, certificate authority,
+// TLS server name, proxy URL and TLS verification
+func ImportClusters(path string, contextFilter string, base Cluster) ([]Cluster, error) {
+	var (
+		clusters [][]Cluster
+		filter   *regexp.Regexp
+	)
+	if contextFilter != "" {
+		var err error
+		if filter, err = regexp.Compile(contextFilter); err != nil {
+			return nil, fmt.Errorf("invalid context filter: %v", err)
+		}
+	}
+	files, err := kubeconfigFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	// Clusters are deduplicated per file, files
+	// of a directory may use the same cluster names
+	for _, file := range files {
+		var fileClusters []Cluster
+		kc, err := kube.LoadKubeconfig(file)
+		if err != nil {
+			return nil, err
+		}
+		imported := make(map[string]bool)
+		for _, ctx := range kc.Contexts {
+			if filter != nil && !filter.MatchString(ctx.Name) {
+				continue
+			}
+			if imported[ctx.Context.Cluster] {
+				continue
+			}
+			kcCluster, ok := kc.Cluster(ctx.Context.Cluster)
+			if !ok {
+				return nil, fmt.Errorf("cluster %q of context %q not found in %q", ctx.Context.Cluster, ctx.Name, file)
+			}
+			c, err := importCluster(file, ctx.Context.Cluster, kcCluster, base)
+			if err != nil {
+				return nil, err
+			}
+			imported[ctx.Context.Cluster] = true
+			fileClusters = append(fileClusters, c)
+		}
+		clusters = append(clusters, fileClusters)
+	}
+	return uniqueNames(files, clusters)
+}
+
+// uniqueNames merges clusters imported from files. Clusters
+// whose name is used in several files are prefixed by the name
+// of their file without extension (ex: "prod-kubernetes" for
+// the "kubernetes" cluster of "prod.yaml")
+func uniqueNames(files []string, clusters [][]Cluster) ([]Cluster, error) {
+	var result []Cluster
+	nameFiles := make(map[string]int)
+	for _, fileClusters := range clusters {
+		for _, c := range fileClusters {
+			nameFiles[c.Name]++
+		}
+	}
+	names := make(map[string]string)
+	for i, fileClusters := range clusters {
+		stem := strings.TrimSuffix(filepath.Base(files[i]), filepath.Ext(files[i]))
+		for _, c := range fileClusters {
+			if nameFiles[c.Name] > 1 {
+				c.Name = stem + "-" + c.Name
+			}
+			if file, ok := names[c.Name]; ok {
+				return nil, fmt.Errorf("duplicate cluster name %q in %q and %q", c.Name, file, files[i])
+			}
+			names[c.Name] = files[i]
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func importCluster(file string, name string, kc kube.Cluster, base Cluster) (Cluster, error) {
+	c := base
+	c.Name = name
+	c.Server = kc.Server
+	c.TLSServerName = kc.TLSServerName
+	c.ProxyURL = kc.ProxyURL
+	c.InsecureSkipTLSVerify = kc.InsecureSkipTLSVerify
+	c.CertificateAuthority = ""
+	c.CertificateAuthorityFile = ""
+	c.Kubeconfig = ""
+	c.ContextFilter = ""
+	switch {
+	case kc.CertificateAuthorityData != "":
+		ca, err := base64.StdEncoding.DecodeString(kc.CertificateAuthorityData)
+		if err != nil {
+			return c, fmt.Errorf("invalid certificate-authority-data for cluster %q in %q: %v", name, file, err)
+		}
+		c.CertificateAuthority = string(ca)
+	case kc.CertificateAuthority != "":
+		// Relative paths are relative to the kubeconfig file
+		caFile := kc.CertificateAuthority
+		if !filepath.IsAbs(caFile) {
+			caFile = filepath.Join(filepath.Dir(file), caFile)
+		}
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return c, fmt.Errorf("failed to read certificate-authority for cluster %q in %q: %v", name, file, err)
+		}
+		c.CertificateAuthority = string(ca)
+	}
+	return c, nil
+}
+
+// kubeconfigFiles returns path if it is a file, or
+// the regular non-hidden files of path if it is a directory
+func kubeconfigFiles(path string) ([]string, error) {
+	var files []string
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Mode().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
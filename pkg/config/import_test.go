@@ -0,0 +1,126 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// kubeadmKubeconfig is a kubeconfig generated by kubeadm
+// for server, with the default cluster name
+const kubeadmKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: kubernetes
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+contexts:
+- name: kubernetes-admin@kubernetes
+  context:
+    cluster: kubernetes
+    user: kubernetes-admin
+- name: other@kubernetes
+  context:
+    cluster: kubernetes
+    user: other
+users:
+- name: kubernetes-admin
+  user:
+    token: secret
+`
+
+func writeKubeconfigs(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "loginapp-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func clusterNames(clusters []Cluster) map[string]string {
+	names := make(map[string]string)
+	for _, c := range clusters {
+		names[c.Name] = c.Server
+	}
+	return names
+}
+
+func TestImportClustersDirectory(t *testing.T) {
+	dir := writeKubeconfigs(t, map[string]string{
+		"prod.yaml":    fmt.Sprintf(kubeadmKubeconfig, "https://prod:6443"),
+		"staging.yaml": fmt.Sprintf(kubeadmKubeconfig, "https://staging:6443"),
+	})
+	defer os.RemoveAll(dir)
+	clusters, err := ImportClusters(dir, "", Cluster{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"prod-kubernetes":    "https://prod:6443",
+		"staging-kubernetes": "https://staging:6443",
+	}
+	if got := clusterNames(clusters); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestImportClustersFile(t *testing.T) {
+	dir := writeKubeconfigs(t, map[string]string{
+		"config": fmt.Sprintf(kubeadmKubeconfig, "https://prod:6443"),
+	})
+	defer os.RemoveAll(dir)
+	// Clusters referenced by several contexts are imported once,
+	// names are kept when they do not collide across files
+	clusters, err := ImportClusters(filepath.Join(dir, "config"), "", Cluster{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clusterNames(clusters), map[string]string{"kubernetes": "https://prod:6443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestImportClustersCollision(t *testing.T) {
+	dir := writeKubeconfigs(t, map[string]string{
+		// The prefixed name of a.yaml collides with the cluster of b.yaml
+		"a.yaml": fmt.Sprintf(kubeadmKubeconfig, "https://a:6443"),
+		"b.yaml": fmt.Sprintf(kubeadmKubeconfig, "https://b:6443"),
+		"c.yaml": `apiVersion: v1
+clusters:
+- name: a-kubernetes
+  cluster:
+    server: https://c:6443
+contexts:
+- name: c
+  context:
+    cluster: a-kubernetes
+`,
+	})
+	defer os.RemoveAll(dir)
+	if _, err := ImportClusters(dir, "", Cluster{}); err == nil {
+		t.Errorf("duplicate cluster names accepted")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRedactQueryParams are the query parameters
+// redacted from logged urls by default
+var DefaultRedactQueryParams = []string{"code", "state", "id_token", "access_token", "refresh_token", "client_secret"}
+
+// Apply configures the logger. Empty format and
+// level default to json and info
+func (l *Log) Apply() error {
+	formatter, level, err := l.parse()
+	if err != nil {
+		return err
+	}
+	log.SetFormatter(formatter)
+	log.SetLevel(level)
+	return nil
+}
+
+func (l *Log) parse() (log.Formatter, log.Level, error) {
+	var formatter log.Formatter
+	switch l.Format {
+	case "", "json":
+		formatter = &log.JSONFormatter{}
+	case "text":
+		formatter = &log.TextFormatter{FullTimestamp: true}
+	default:
+		return nil, 0, fmt.Errorf("unknown log format %q, must be json or text", l.Format)
+	}
+	level := log.InfoLevel
+	if l.Level != "" {
+		var err error
+		if level, err = log.ParseLevel(l.Level); err != nil {
+			return nil, 0, err
+		}
+	}
+	return formatter, level, nil
+}
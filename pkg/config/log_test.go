@@ -0,0 +1,52 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLogParse(t *testing.T) {
+	tests := []struct {
+		log     Log
+		text    bool
+		level   log.Level
+		wantErr bool
+	}{
+		{Log{}, false, log.InfoLevel, false},
+		{Log{Format: "text", Level: "debug"}, true, log.DebugLevel, false},
+		{Log{Format: "json", Level: "warning"}, false, log.WarnLevel, false},
+		{Log{Format: "xml"}, false, 0, true},
+		{Log{Level: "verbose"}, false, 0, true},
+	}
+	for _, tt := range tests {
+		formatter, level, err := tt.log.parse()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: unexpected error: %v", tt.log, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if _, text := formatter.(*log.TextFormatter); text != tt.text {
+			t.Errorf("%+v: got formatter %T", tt.log, formatter)
+		}
+		if level != tt.level {
+			t.Errorf("%+v: got level %s, want %s", tt.log, level, tt.level)
+		}
+	}
+}
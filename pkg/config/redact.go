@@ -0,0 +1,73 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "net/url"
+
+// Redacted returns a copy of the configuration with secrets
+// replaced by replacement. Values of free form options (extra
+// kubeconfig and auth code options, webhook headers) may hold
+// credentials and are redacted, their keys are kept
+func (a App) Redacted(replacement string) App {
+	redact := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return replacement
+	}
+	a.Secret = redact(a.Secret)
+	a.OIDC.Client.Secret = redact(a.OIDC.Client.Secret)
+	a.OIDC.Extra.AuthCodeOpts = redactValues(a.OIDC.Extra.AuthCodeOpts, redact)
+	a.Web.Kubeconfig.ExtraOpts = redactValues(a.Web.Kubeconfig.ExtraOpts, redact)
+	a.Metrics.BasicAuth.Password = redact(a.Metrics.BasicAuth.Password)
+	a.Audit.Webhook.URL = redactURL(a.Audit.Webhook.URL, replacement)
+	a.Audit.Webhook.Headers = redactValues(a.Audit.Webhook.Headers, redact)
+	return a
+}
+
+// redactValues returns a copy of m with values redacted
+func redactValues(m map[string]string, redact func(string) string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	r := make(map[string]string, len(m))
+	for k, v := range m {
+		r[k] = redact(v)
+	}
+	return r
+}
+
+// redactURL returns rawURL with the user info and the query
+// values replaced by replacement. Unparsable urls are replaced
+func redactURL(rawURL string, replacement string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return replacement
+	}
+	if u.User != nil {
+		u.User = url.User(replacement)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k := range query {
+			query[k] = []string{replacement}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
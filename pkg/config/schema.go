@@ -0,0 +1,93 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SchemaURI is the JSON schema version of the configuration schema
+const SchemaURI = "http://json-schema.org/draft-07/schema#"
+
+// schemaEnums are the allowed values of configuration keys
+var schemaEnums = map[string][]interface{}{
+	"version":          {"v1"},
+	"log.format":       {"json", "text"},
+	"log.level":        {"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"},
+	"tracing.exporter": {"otlp", "stdout"},
+}
+
+// Schema returns the JSON schema of the configuration file.
+// descriptions are the descriptions of configuration keys,
+// indexed by lower case key (ex: "oidc.client.id")
+func Schema(descriptions map[string]string) map[string]interface{} {
+	s := schema(reflect.TypeOf(App{}), "", descriptions)
+	s["$schema"] = SchemaURI
+	s["title"] = "Loginapp configuration"
+	// Loginapp reads keys case insensitively, the schema does not
+	s["description"] = "Loginapp configuration. Keys must be spelled as in this schema (ex: redirectURL), 'loginapp config validate' reports other spellings"
+	return s
+}
+
+func schema(t reflect.Type, path string, descriptions map[string]string) map[string]interface{} {
+	s := make(map[string]interface{})
+	if d, ok := descriptions[strings.ToLower(path)]; ok {
+		s["description"] = d
+	}
+	if e, ok := schemaEnums[strings.ToLower(path)]; ok {
+		s["enum"] = e
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		// Durations are strings (ex: "10m") or nanoseconds
+		s["type"] = []string{"string", "integer"}
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schema(t.Elem(), path, descriptions)
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	case reflect.Slice, reflect.Array:
+		s["type"] = "array"
+		s["items"] = schema(t.Elem(), path+"[]", descriptions)
+	case reflect.Map:
+		s["type"] = "object"
+		if t.Elem().Kind() != reflect.Interface {
+			s["additionalProperties"] = schema(t.Elem(), join(path, "*"), descriptions)
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			properties[Key(f)] = schema(f.Type, join(path, Key(f)), descriptions)
+		}
+		s["type"] = "object"
+		s["properties"] = properties
+		s["additionalProperties"] = false
+	}
+	return s
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CurrentVersion is the version of the configuration
+	// format, incremented on breaking changes
+	CurrentVersion = "v1"
+)
+
+// Versions are the supported configuration versions, oldest first
+var Versions = []string{"v1"}
+
+// keySegment is a segment of a configuration key,
+// with an optional list index (ex: "clusters[0]")
+var keySegment = regexp.MustCompile(`^(.*?)(?:\[(\d+)\])?$`)
+
+// supportedVersion reports if configuration version v is supported
+func supportedVersion(v string) bool {
+	for _, version := range Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownKeyChecks returns a failed check for each unknown
+// key of the configuration settings, with its location
+// in the configuration file. Flags and environment vars
+// cannot set unknown keys
+func unknownKeyChecks(settings map[string]interface{}) []Check {
+	var checks []Check
+	file := viper.ConfigFileUsed()
+	for _, key := range UnknownKeys(settings) {
+		checks = append(checks, Check{true, key, fmt.Sprintf("unknown configuration key %s (%s)", key, KeyLocation(file, key)), nil})
+	}
+	return checks
+}
+
+// keyCaseChecks returns a failed check for each key of the
+// configuration file not spelled like the configuration key.
+// Keys are case insensitive, but the JSON schema is not
+func keyCaseChecks(file string) []Check {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	return keyCases(reflect.TypeOf(App{}), doc.Content[0], "", file)
+}
+
+func keyCases(t reflect.Type, node *yaml.Node, path string, file string) []Check {
+	var checks []Check
+	switch t.Kind() {
+	case reflect.Ptr:
+		return keyCases(t.Elem(), node, path, file)
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i]
+			f, ok := field(t, k.Value)
+			if !ok {
+				// Reported by unknownKeyChecks
+				continue
+			}
+			key := join(path, Key(f))
+			checks = append(checks, Check{k.Value != Key(f), key, fmt.Sprintf("%s is spelled %s (%s:%d), keys of the configuration schema are case sensitive", key, k.Value, file, k.Line), nil})
+			checks = append(checks, keyCases(f.Type, node.Content[i+1], key, file)...)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for i, item := range node.Content {
+			checks = append(checks, keyCases(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i), file)...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checks = append(checks, keyCases(t.Elem(), node.Content[i+1], join(path, node.Content[i].Value), file)...)
+		}
+	}
+	return checks
+}
+
+// KeyLocation returns the location "file:line" of a configuration
+// key (ex: "clusters[0].name") in a yaml file. Keys are case
+// insensitive. file is returned if the key is not found
+func KeyLocation(file string, key string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return file
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil || len(doc.Content) == 0 {
+		return file
+	}
+	node := doc.Content[0]
+	line := node.Line
+	for _, segment := range strings.Split(key, ".") {
+		m := keySegment.FindStringSubmatch(segment)
+		if node = mappingValue(node, m[1]); node == nil {
+			return file
+		}
+		line = node.Line
+		if m[2] != "" {
+			i, _ := strconv.Atoi(m[2])
+			if node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+				return file
+			}
+			node = node.Content[i]
+			line = node.Line
+		}
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// mappingValue returns the value of key in a mapping node.
+// The line of the key is set on the returned node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			value := *node.Content[i+1]
+			value.Line = node.Content[i].Line
+			return &value
+		}
+	}
+	return nil
+}
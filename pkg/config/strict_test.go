@@ -0,0 +1,55 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyCaseChecks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(`name: test
+OIDC:
+  client:
+    redirectUrl: http://127.0.0.1:8080/callback
+  extra:
+    authCodeOpts:
+      Resource: api
+clusters:
+- name: a
+  Server: https://a:6443
+  typo: true
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range keyCaseChecks(file) {
+		if c.FailedCondition {
+			got = append(got, c.Path)
+		}
+	}
+	// Map keys (authCodeOpts) are free, unknown keys are reported by unknownKeyChecks
+	want := []string{"oidc", "oidc.client.redirectURL", "clusters[0].server"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fydrah/loginapp/pkg/kube"
+	"github.com/spf13/viper"
+)
+
+// Problem is a configuration problem, found at
+// Path (ex: "oidc.client.id")
+type Problem struct {
+	Path    string
+	Message string
+	// Warning problems do not prevent loginapp to start
+	Warning bool
+}
+
+func (p Problem) String() string {
+	severity := "error"
+	if p.Warning {
+		severity = "warning"
+	}
+	return fmt.Sprintf("%s: %s: %s", severity, p.Path, p.Message)
+}
+
+// Validate loads the configuration like Init, without logging
+// checks, and returns every problem found: failed error checks,
+// unknown keys, unreadable files, inconsistent settings and
+// warnings, including keys not spelled like the JSON schema.
+// Default values are set
+func (a *App) Validate() ([]Problem, error) {
+	settings, deprecations, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for _, d := range deprecations {
+		problems = append(problems, Problem{d.Key, fmt.Sprintf("deprecated, use %s (run 'loginapp config migrate')", d.NewKey), true})
+	}
+	checks := append(unknownKeyChecks(settings), a.LoadChecks()...)
+	for _, c := range append(append(checks, a.ErrorChecks()...), a.deepChecks()...) {
+		if c.FailedCondition {
+			problems = append(problems, Problem{c.Path, c.Message, false})
+		}
+	}
+	for _, c := range a.DefaultChecks() {
+		if !c.FailedCondition {
+			continue
+		}
+		if c.DefaultAction != nil {
+			c.DefaultAction()
+			continue
+		}
+		problems = append(problems, Problem{c.Path, c.Message, true})
+	}
+	for _, c := range append(keyCaseChecks(viper.ConfigFileUsed()), a.warningChecks()...) {
+		if c.FailedCondition {
+			problems = append(problems, Problem{c.Path, c.Message, true})
+		}
+	}
+	return problems, nil
+}
+
+// deepChecks are error checks reading files, not
+// run by Init since loginapp reports these errors
+// when it starts
+func (a *App) deepChecks() []Check {
+	rootCAErr := checkCertificatesFile(a.OIDC.Issuer.RootCA)
+	tlsErr := checkKeyPair(a.TLS)
+	metricsTLSErr := checkKeyPair(a.Metrics.TLS)
+	redirectURL, redirectErr := url.Parse(a.OIDC.Client.RedirectURL)
+	if redirectErr == nil && (redirectURL.Host == "" || (redirectURL.Scheme != "http" && redirectURL.Scheme != "https")) {
+		redirectErr = fmt.Errorf("must be an absolute http(s) url")
+	}
+	checks := []Check{
+		{!a.OIDC.Issuer.InsecureSkipVerify && a.OIDC.Issuer.RootCA != "" && rootCAErr != nil, "oidc.issuer.rootCA", fmt.Sprintf("invalid oidc.issuer.rootCA: %v", rootCAErr), nil},
+		{a.TLS.Enabled && tlsErr != nil, "tls", fmt.Sprintf("invalid tls certificate: %v", tlsErr), nil},
+		{a.Metrics.Enabled && !a.Metrics.MainListener && a.Metrics.TLS.Enabled && metricsTLSErr != nil, "metrics.tls", fmt.Sprintf("invalid metrics.tls certificate: %v", metricsTLSErr), nil},
+		{a.OIDC.Client.RedirectURL != "" && redirectErr != nil, "oidc.client.redirectURL", fmt.Sprintf("invalid oidc.client.redirectURL %q: %v", a.OIDC.Client.RedirectURL, redirectErr), nil},
+		{redirectErr == nil && !strings.HasSuffix(redirectURL.Path, "/callback"), "oidc.client.redirectURL", "oidc.client.redirectURL must end with '/callback'", nil},
+	}
+	for i, c := range a.Clusters {
+		for _, other := range a.Clusters[:i] {
+			checks = append(checks,
+				Check{c.Name != "" && c.Name == other.Name, fmt.Sprintf("clusters[%d].name", i), fmt.Sprintf("duplicate clusters[%d].name %q", i, c.Name), nil},
+			)
+		}
+	}
+	for i, o := range a.Web.Outputs {
+		_, err := ioutil.ReadFile(o.TemplateFile)
+		checks = append(checks,
+			Check{o.TemplateFile != "" && err != nil, fmt.Sprintf("web.outputs[%d].templateFile", i), fmt.Sprintf("invalid web.outputs[%d].templateFile: %v", i, err), nil},
+		)
+	}
+	return checks
+}
+
+// warningChecks are checks of settings which are valid
+// but are likely mistakes, reported by Validate only
+func (a *App) warningChecks() []Check {
+	redirectURL, err := url.Parse(a.OIDC.Client.RedirectURL)
+	if err != nil || a.OIDC.Client.RedirectURL == "" {
+		return nil
+	}
+	listenHost, listenPort, _ := net.SplitHostPort(a.Listen)
+	// Checks apply when loginapp is reached
+	// directly, without a reverse proxy
+	direct := redirectURL.Hostname() == "localhost" || redirectURL.Hostname() == listenHost
+	if ip := net.ParseIP(redirectURL.Hostname()); ip != nil && ip.IsLoopback() {
+		direct = true
+	}
+	scheme := "http"
+	if a.TLS.Enabled {
+		scheme = "https"
+	}
+	port := redirectURL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[redirectURL.Scheme]
+	}
+	return []Check{
+		{a.TLS.Enabled && !direct && redirectURL.Scheme == "http", "oidc.client.redirectURL", "oidc.client.redirectURL uses http while tls is enabled", nil},
+		{direct && redirectURL.Scheme != scheme, "oidc.client.redirectURL", fmt.Sprintf("oidc.client.redirectURL uses %s while loginapp serves %s", redirectURL.Scheme, scheme), nil},
+		{direct && listenPort != "" && port != listenPort, "oidc.client.redirectURL", fmt.Sprintf("oidc.client.redirectURL port %s does not match listen port %s", port, listenPort), nil},
+	}
+}
+
+// checkCertificatesFile returns an error if path
+// does not contain pem encoded certificates
+func checkCertificatesFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = kube.ParseCertificates(string(b))
+	return err
+}
+
+// checkKeyPair returns an error if the certificate
+// and key of t cannot be loaded
+func checkKeyPair(t TLS) error {
+	if t.Cert == "" || t.Key == "" {
+		return nil
+	}
+	_, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	return err
+}
+
+// UnknownKeys returns the keys of settings which are not
+// configuration keys, sorted. Settings keys are case insensitive
+func UnknownKeys(settings map[string]interface{}) []string {
+	keys := unknownKeys(reflect.TypeOf(App{}), settings, "")
+	sort.Strings(keys)
+	return keys
+}
+
+func unknownKeys(t reflect.Type, v interface{}, path string) []string {
+	var keys []string
+	switch t.Kind() {
+	case reflect.Ptr:
+		return unknownKeys(t.Elem(), v, path)
+	case reflect.Struct:
+		m, ok := stringMap(v)
+		if !ok {
+			return nil
+		}
+		for k, value := range m {
+			f, ok := field(t, k)
+			if !ok {
+				keys = append(keys, join(path, k))
+				continue
+			}
+			keys = append(keys, unknownKeys(f.Type, value, join(path, Key(f)))...)
+		}
+	case reflect.Slice, reflect.Array:
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, value := range l {
+			keys = append(keys, unknownKeys(t.Elem(), value, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Map:
+		m, ok := stringMap(v)
+		if !ok {
+			return nil
+		}
+		for k, value := range m {
+			keys = append(keys, unknownKeys(t.Elem(), value, join(path, k))...)
+		}
+	}
+	return keys
+}
+
+// field returns the field of struct t with key k
+func field(t reflect.Type, k string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" && strings.EqualFold(Key(f), k) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// stringMap converts maps decoded from yaml or json
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		sm := make(map[string]interface{}, len(m))
+		for k, value := range m {
+			sm[fmt.Sprint(k)] = value
+		}
+		return sm, true
+	}
+	return nil, false
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kube provides a minimal Kubernetes API client,
+// limited to the few API calls performed by loginapp
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/logging"
+)
+
+// idleConnTimeout is the time idle connections
+// to the API server are kept open
+const idleConnTimeout = 90 * time.Second
+
+// Client is a Kubernetes API client
+type Client struct {
+	Server      string
+	BearerToken string
+	HTTPClient  *http.Client
+}
+
+// ClientConfig is the configuration of a Client
+type ClientConfig struct {
+	Server string
+	// CertificateAuthority is the PEM encoded certificate
+	// authority of the API server, system roots are used if empty
+	CertificateAuthority  string
+	InsecureSkipTLSVerify bool
+	TLSServerName         string
+	ProxyURL              string
+	BearerToken           string
+	Timeout               time.Duration
+}
+
+// NewClient returns a client for the API server described by cfg
+func NewClient(cfg ClientConfig) (*Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipTLSVerify,
+		ServerName:         cfg.TLSServerName,
+	}
+	if !cfg.InsecureSkipTLSVerify && cfg.CertificateAuthority != "" {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(cfg.CertificateAuthority)) {
+			return nil, fmt.Errorf("no certs found in certificate authority of %q", cfg.Server)
+		}
+	}
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %q: %v", cfg.ProxyURL, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	return &Client{
+		Server:      strings.TrimSuffix(cfg.Server, "/"),
+		BearerToken: cfg.BearerToken,
+		HTTPClient: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &logging.Transport{
+				Base: &http.Transport{
+					TLSClientConfig:     tlsConfig,
+					Proxy:               proxy,
+					TLSHandshakeTimeout: 10 * time.Second,
+					IdleConnTimeout:     idleConnTimeout,
+				},
+			},
+		},
+	}, nil
+}
+
+// WithBearerToken returns a copy of c authenticating
+// with token, sharing the connections of c
+func (c *Client) WithBearerToken(token string) *Client {
+	client := *c
+	client.BearerToken = token
+	return &client
+}
+
+// Do performs a request against the API server. in is
+// sent as json body if not nil, and the json response
+// is decoded into out if not nil
+func (c *Client) Do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.Server+path, &body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp.StatusCode, respBody)
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return nil
+}
+
+// statusError returns an error from a Kubernetes Status
+// object if the body contains one
+func statusError(code int, body []byte) error {
+	var status struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &status); err == nil && status.Message != "" {
+		return fmt.Errorf("%d %s: %s", code, status.Reason, status.Message)
+	}
+	return fmt.Errorf("unexpected status code %d", code)
+}
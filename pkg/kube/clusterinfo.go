@@ -0,0 +1,126 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const clusterInfoPath = "/api/v1/namespaces/kube-public/configmaps/cluster-info"
+
+// ParseCertificates returns the certificates of a PEM bundle,
+// and fails if the bundle contains no certificate
+func ParseCertificates(bundle string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// CACertHash returns the hash of a certificate public key,
+// in the format used by 'kubeadm join --discovery-token-ca-cert-hash'
+func CACertHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// DiscoverCA fetches the certificate authority of the API server
+// described by cfg from the kube-public/cluster-info configmap.
+// Only the certificates matching caCertHash are trusted, the API
+// server certificate is then verified against them before
+// returning them as a PEM bundle
+func DiscoverCA(ctx context.Context, cfg ClientConfig, caCertHash string) (string, error) {
+	var cm struct {
+		Data map[string]string `json:"data"`
+	}
+	cfg.InsecureSkipTLSVerify = true
+	cfg.BearerToken = ""
+	insecureClient, err := NewClient(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer insecureClient.HTTPClient.CloseIdleConnections()
+	if err := insecureClient.Do(ctx, http.MethodGet, clusterInfoPath, nil, &cm); err != nil {
+		return "", fmt.Errorf("failed to fetch cluster-info: %v", err)
+	}
+	var kc Kubeconfig
+	if err := yaml.Unmarshal([]byte(cm.Data["kubeconfig"]), &kc); err != nil {
+		return "", fmt.Errorf("failed to parse cluster-info kubeconfig: %v", err)
+	}
+	if len(kc.Clusters) == 0 {
+		return "", fmt.Errorf("no cluster found in cluster-info kubeconfig")
+	}
+	caPEM, err := base64.StdEncoding.DecodeString(kc.Clusters[0].Cluster.CertificateAuthorityData)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode cluster-info certificate-authority-data: %v", err)
+	}
+	certs, err := ParseCertificates(string(caPEM))
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster-info certificate authority: %v", err)
+	}
+	// Only pinned certificates are trusted, other
+	// certificates of the bundle may be injected
+	var pinned bytes.Buffer
+	for _, cert := range certs {
+		if strings.EqualFold(CACertHash(cert), caCertHash) {
+			if err := pem.Encode(&pinned, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				return "", err
+			}
+		}
+	}
+	if pinned.Len() == 0 {
+		return "", fmt.Errorf("cluster-info certificate authority does not match hash %q", caCertHash)
+	}
+	// Check that the server we talked to is
+	// really served by the pinned authority
+	cfg.InsecureSkipTLSVerify = false
+	cfg.CertificateAuthority = pinned.String()
+	client, err := NewClient(cfg)
+	if err != nil {
+		return "", err
+	}
+	defer client.HTTPClient.CloseIdleConnections()
+	if err := client.Do(ctx, http.MethodGet, clusterInfoPath, nil, nil); err != nil {
+		return "", fmt.Errorf("failed to verify API server with the discovered certificate authority: %v", err)
+	}
+	return pinned.String(), nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// selfSignedCert returns a self-signed CA certificate valid for 127.0.0.1
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "attacker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func encodePEM(certs ...*x509.Certificate) string {
+	var b strings.Builder
+	for _, c := range certs {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return b.String()
+}
+
+// clusterInfoServer serves a cluster-info configmap with bundle
+// as certificate authority. The server certificate is cert if
+// not nil, the httptest certificate otherwise
+func clusterInfoServer(t *testing.T, cert *tls.Certificate, bundle func(s *httptest.Server) string) *httptest.Server {
+	var s *httptest.Server
+	s = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kc := NewKubeconfig()
+		kc.Clusters = []NamedCluster{{Cluster: Cluster{
+			Server:                   s.URL,
+			CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte(bundle(s))),
+		}}}
+		b, err := yaml.Marshal(kc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"kubeconfig": string(b)}})
+	}))
+	if cert != nil {
+		s.TLS = &tls.Config{Certificates: []tls.Certificate{*cert}}
+	}
+	s.StartTLS()
+	return s
+}
+
+func TestDiscoverCA(t *testing.T) {
+	attacker := selfSignedCert(t)
+	// Genuine server, with an injected certificate in the bundle
+	s := clusterInfoServer(t, nil, func(s *httptest.Server) string {
+		return encodePEM(attacker.Leaf, s.Certificate())
+	})
+	defer s.Close()
+	genuineHash := CACertHash(s.Certificate())
+
+	ca, err := DiscoverCA(context.Background(), ClientConfig{Server: s.URL, Timeout: time.Second}, genuineHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca != encodePEM(s.Certificate()) {
+		t.Errorf("unpinned certificates returned:\n%s", ca)
+	}
+
+	if _, err := DiscoverCA(context.Background(), ClientConfig{Server: s.URL, Timeout: time.Second}, CACertHash(attacker.Leaf)+"00"); err == nil {
+		t.Errorf("unpinned certificate authority accepted")
+	}
+}
+
+func TestDiscoverCAManInTheMiddle(t *testing.T) {
+	attacker := selfSignedCert(t)
+	genuine := httptest.NewTLSServer(http.NotFoundHandler())
+	defer genuine.Close()
+	// The attacker serves its own certificate, and adds
+	// its authority next to the genuine one
+	s := clusterInfoServer(t, &attacker, func(*httptest.Server) string {
+		return encodePEM(attacker.Leaf, genuine.Certificate())
+	})
+	defer s.Close()
+	if _, err := DiscoverCA(context.Background(), ClientConfig{Server: s.URL, Timeout: time.Second}, CACertHash(genuine.Certificate())); err == nil {
+		t.Errorf("API server certificate signed by an unpinned authority accepted")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ServiceAccountDir is the directory where the pod
+// service account credentials are mounted
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// InClusterConfig returns the client configuration of the pod
+// service account, and the namespace of the pod
+func InClusterConfig() (ClientConfig, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return ClientConfig{}, "", fmt.Errorf("not running inside kubernetes, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be defined")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "token"))
+	if err != nil {
+		return ClientConfig{}, "", err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return ClientConfig{}, "", err
+	}
+	namespace, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+	if err != nil {
+		return ClientConfig{}, "", err
+	}
+	return ClientConfig{
+		Server:               "https://" + net.JoinHostPort(host, port),
+		CertificateAuthority: string(ca),
+		BearerToken:          strings.TrimSpace(string(token)),
+	}, strings.TrimSpace(string(namespace)), nil
+}
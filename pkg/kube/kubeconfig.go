@@ -0,0 +1,140 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Kubeconfig is a kubectl configuration file
+// (see k8s.io/client-go/tools/clientcmd/api/v1)
+type Kubeconfig struct {
+	APIVersion     string         `yaml:"apiVersion"`
+	Kind           string         `yaml:"kind"`
+	Preferences    struct{}       `yaml:"preferences"`
+	CurrentContext string         `yaml:"current-context"`
+	Contexts       []NamedContext `yaml:"contexts"`
+	Clusters       []NamedCluster `yaml:"clusters"`
+	Users          []NamedUser    `yaml:"users"`
+}
+
+// NamedCluster is a named kubeconfig cluster
+type NamedCluster struct {
+	Name    string  `yaml:"name"`
+	Cluster Cluster `yaml:"cluster"`
+}
+
+// Cluster contains information about how
+// to communicate with a kubernetes cluster
+type Cluster struct {
+	Server                   string           `yaml:"server"`
+	TLSServerName            string           `yaml:"tls-server-name,omitempty"`
+	CertificateAuthority     string           `yaml:"certificate-authority,omitempty"`
+	CertificateAuthorityData string           `yaml:"certificate-authority-data,omitempty"`
+	InsecureSkipTLSVerify    bool             `yaml:"insecure-skip-tls-verify"`
+	ProxyURL                 string           `yaml:"proxy-url,omitempty"`
+	DisableCompression       bool             `yaml:"disable-compression,omitempty"`
+	Extensions               []NamedExtension `yaml:"extensions,omitempty"`
+}
+
+// NamedExtension is a named kubeconfig extension
+type NamedExtension struct {
+	Name      string      `yaml:"name"`
+	Extension interface{} `yaml:"extension"`
+}
+
+// NamedContext is a named kubeconfig context
+type NamedContext struct {
+	Name    string  `yaml:"name"`
+	Context Context `yaml:"context"`
+}
+
+// Context is a tuple of references to a cluster,
+// a user and a namespace
+type Context struct {
+	User      string `yaml:"user"`
+	Cluster   string `yaml:"cluster"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// NamedUser is a named kubeconfig user
+type NamedUser struct {
+	Name string `yaml:"name"`
+	User User   `yaml:"user"`
+}
+
+// User contains information that describes identity
+// information, limited to the auth provider for loginapp
+type User struct {
+	AuthProvider *AuthProvider `yaml:"auth-provider,omitempty"`
+}
+
+// AuthProvider holds the configuration for a
+// specified authentication provider
+type AuthProvider struct {
+	Config map[string]string `yaml:"config"`
+	Name   string            `yaml:"name"`
+}
+
+// NewKubeconfig returns an empty kubeconfig
+func NewKubeconfig() *Kubeconfig {
+	return &Kubeconfig{
+		APIVersion: "v1",
+		Kind:       "Config",
+	}
+}
+
+// Marshal returns the yaml representation of the kubeconfig
+func (k *Kubeconfig) Marshal() ([]byte, error) {
+	return yaml.Marshal(k)
+}
+
+// NewExtensions converts a map of extensions
+// into a list of named extensions, sorted by name
+func NewExtensions(extensions map[string]interface{}) []NamedExtension {
+	var named []NamedExtension
+	for name, extension := range extensions {
+		named = append(named, NamedExtension{Name: name, Extension: extension})
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].Name < named[j].Name })
+	return named
+}
+
+// LoadKubeconfig reads and parses a kubeconfig file
+func LoadKubeconfig(path string) (*Kubeconfig, error) {
+	var kc Kubeconfig
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %q: %v", path, err)
+	}
+	return &kc, nil
+}
+
+// Cluster returns the cluster named name
+func (k *Kubeconfig) Cluster(name string) (Cluster, bool) {
+	for _, c := range k.Clusters {
+		if c.Name == name {
+			return c.Cluster, true
+		}
+	}
+	return Cluster{}, false
+}
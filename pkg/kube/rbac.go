@@ -0,0 +1,95 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"net/http"
+)
+
+const authorizationAPI = "/apis/authorization.k8s.io/v1"
+
+// ResourceRule is the list of actions the subject is
+// allowed to perform on resources
+type ResourceRule struct {
+	Verbs         []string `json:"verbs"`
+	APIGroups     []string `json:"apiGroups,omitempty"`
+	Resources     []string `json:"resources,omitempty"`
+	ResourceNames []string `json:"resourceNames,omitempty"`
+}
+
+// NonResourceRule is the list of actions the subject is
+// allowed to perform on non-resources
+type NonResourceRule struct {
+	Verbs           []string `json:"verbs"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+}
+
+// RulesReview is the result of a SelfSubjectRulesReview
+// for a namespace
+type RulesReview struct {
+	Namespace        string
+	ResourceRules    []ResourceRule    `json:"resourceRules"`
+	NonResourceRules []NonResourceRule `json:"nonResourceRules"`
+	Incomplete       bool              `json:"incomplete"`
+	EvaluationError  string            `json:"evaluationError,omitempty"`
+}
+
+// ResourceAttributes describes an action checked
+// with a SelfSubjectAccessReview
+type ResourceAttributes struct {
+	Namespace string `json:"namespace,omitempty"`
+	Verb      string `json:"verb,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// SelfSubjectRulesReview returns the set of actions the
+// client identity can perform within namespace
+func (c *Client) SelfSubjectRulesReview(ctx context.Context, namespace string) (*RulesReview, error) {
+	var review struct {
+		Status RulesReview `json:"status"`
+	}
+	req := map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectRulesReview",
+		"spec":       map[string]string{"namespace": namespace},
+	}
+	if err := c.Do(ctx, http.MethodPost, authorizationAPI+"/selfsubjectrulesreviews", req, &review); err != nil {
+		return nil, err
+	}
+	review.Status.Namespace = namespace
+	return &review.Status, nil
+}
+
+// SelfSubjectAccessReview checks if the client
+// identity can perform the given action
+func (c *Client) SelfSubjectAccessReview(ctx context.Context, attrs ResourceAttributes) (bool, error) {
+	var review struct {
+		Status struct {
+			Allowed bool `json:"allowed"`
+		} `json:"status"`
+	}
+	req := map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec":       map[string]interface{}{"resourceAttributes": attrs},
+	}
+	if err := c.Do(ctx, http.MethodPost, authorizationAPI+"/selfsubjectaccessreviews", req, &review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeAPIServer serves authorization reviews, and
+// checks requests are authenticated with token
+func fakeAPIServer(t *testing.T, token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"kind":"Status","reason":"Unauthorized","message":"Unauthorized"}`))
+			return
+		}
+		var review struct {
+			Kind string `json:"kind"`
+			Spec struct {
+				Namespace          string             `json:"namespace"`
+				ResourceAttributes ResourceAttributes `json:"resourceAttributes"`
+			} `json:"spec"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			t.Errorf("invalid review: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case authorizationAPI + "/selfsubjectrulesreviews":
+			if review.Kind != "SelfSubjectRulesReview" || review.Spec.Namespace != "dev" {
+				t.Errorf("unexpected rules review %+v", review)
+			}
+			w.Write([]byte(`{"status":{"resourceRules":[{"verbs":["get","list"],"apiGroups":[""],"resources":["pods"]}],"nonResourceRules":[{"verbs":["get"],"nonResourceURLs":["/healthz"]}],"incomplete":true}}`))
+		case authorizationAPI + "/selfsubjectaccessreviews":
+			if review.Kind != "SelfSubjectAccessReview" {
+				t.Errorf("unexpected access review %+v", review)
+			}
+			allowed := review.Spec.ResourceAttributes.Verb == "get"
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": map[string]bool{"allowed": allowed}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestSelfSubjectRulesReview(t *testing.T) {
+	s := fakeAPIServer(t, "token")
+	defer s.Close()
+	c, err := NewClient(ClientConfig{Server: s.URL, BearerToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, err := c.SelfSubjectRulesReview(context.Background(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &RulesReview{
+		Namespace:        "dev",
+		ResourceRules:    []ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		NonResourceRules: []NonResourceRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}},
+		Incomplete:       true,
+	}
+	if !reflect.DeepEqual(review, want) {
+		t.Errorf("got %+v, want %+v", review, want)
+	}
+}
+
+func TestSelfSubjectAccessReview(t *testing.T) {
+	s := fakeAPIServer(t, "token")
+	defer s.Close()
+	c, err := NewClient(ClientConfig{Server: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		client  *Client
+		verb    string
+		allowed bool
+		err     bool
+	}{
+		{c.WithBearerToken("token"), "get", true, false},
+		{c.WithBearerToken("token"), "delete", false, false},
+		{c.WithBearerToken("other"), "get", false, true},
+	}
+	for _, tt := range tests {
+		allowed, err := tt.client.SelfSubjectAccessReview(context.Background(), ResourceAttributes{Verb: tt.verb, Resource: "pods"})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: unexpected error %v", tt.verb, err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%s: got allowed %v, want %v", tt.verb, allowed, tt.allowed)
+		}
+	}
+	if c.BearerToken != "" {
+		t.Errorf("WithBearerToken modified the client")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Object is a kubernetes ConfigMap or Secret,
+// limited to the fields used by loginapp
+type Object struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// Event is a watch event
+type Event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Watch event types
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+	EventBookmark = "BOOKMARK"
+	EventError    = "ERROR"
+)
+
+// Store receives the objects of a list and watch loop
+type Store interface {
+	// Replace is called with the full list of
+	// objects, after every listing
+	Replace(objects []Object)
+	// Update is called for each added or modified object
+	Update(object Object)
+	// Delete is called for each deleted object
+	Delete(object Object)
+}
+
+// ListWatch lists and watches objects of a collection (ex:
+// "/api/v1/namespaces/default/configmaps") matching labelSelector,
+// and keeps store up to date. The collection is listed again
+// every resync period, or when the watch fails. ListWatch
+// returns when ctx is done, or if the listing fails
+func (c *Client) ListWatch(ctx context.Context, collection string, labelSelector string, resync time.Duration, store Store) error {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []Object `json:"items"`
+	}
+	query := url.Values{}
+	query.Set("labelSelector", labelSelector)
+	if err := c.Do(ctx, http.MethodGet, collection+"?"+query.Encode(), nil, &list); err != nil {
+		return fmt.Errorf("failed to list %s: %v", collection, err)
+	}
+	store.Replace(list.Items)
+
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("resourceVersion", list.Metadata.ResourceVersion)
+	query.Set("timeoutSeconds", fmt.Sprintf("%d", int(resync.Seconds())))
+	return c.watch(ctx, collection+"?"+query.Encode(), store)
+}
+
+// watch consumes watch events until the server
+// closes the stream or an error occurs
+func (c *Client) watch(ctx context.Context, path string, store Store) error {
+	req, err := http.NewRequest(http.MethodGet, c.Server+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+	// Watch requests are long running, the
+	// client timeout must not be applied
+	client := *c.HTTPClient
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusError(resp.StatusCode, body)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var (
+			event  Event
+			object Object
+		)
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Stream closed by the server, after timeoutSeconds
+			return nil
+		}
+		switch event.Type {
+		case EventAdded, EventModified, EventDeleted:
+			if err := json.Unmarshal(event.Object, &object); err != nil {
+				return fmt.Errorf("failed to decode watch event: %v", err)
+			}
+			if event.Type == EventDeleted {
+				store.Delete(object)
+			} else {
+				store.Update(object)
+			}
+		case EventError:
+			// Mostly "410 Gone" when the resource
+			// version is too old, list again
+			return statusError(http.StatusGone, event.Object)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStore records the calls of ListWatch
+type recordingStore struct {
+	sync.Mutex
+	calls []string
+}
+
+func (s *recordingStore) record(format string, args ...interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	s.calls = append(s.calls, fmt.Sprintf(format, args...))
+}
+
+func (s *recordingStore) Replace(objects []Object) {
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Metadata.Name+"="+o.Data["k"])
+	}
+	s.record("replace %s", strings.Join(names, ","))
+}
+
+func (s *recordingStore) Update(o Object) {
+	s.record("update %s=%s", o.Metadata.Name, o.Data["k"])
+}
+
+func (s *recordingStore) Delete(o Object) {
+	s.record("delete %s", o.Metadata.Name)
+}
+
+// object returns a ConfigMap json document
+func object(name string, value string, resourceVersion string) string {
+	return fmt.Sprintf(`{"metadata":{"name":%q,"namespace":"default","resourceVersion":%q},"data":{"k":%q}}`, name, resourceVersion, value)
+}
+
+// watchServer serves a ConfigMaps collection: lists return
+// list, watches check the resource version and stream events
+func watchServer(t *testing.T, lists []string, watches [][]string) *httptest.Server {
+	var mu sync.Mutex
+	listed, watched := 0, 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v1/namespaces/default/configmaps" || q.Get("labelSelector") != "app=test" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if q.Get("watch") != "true" {
+			w.Write([]byte(lists[listed]))
+			listed++
+			return
+		}
+		if want := fmt.Sprintf("%d", listed*10); q.Get("resourceVersion") != want {
+			t.Errorf("watch started at resource version %q, want %q", q.Get("resourceVersion"), want)
+		}
+		if q.Get("timeoutSeconds") != "60" {
+			t.Errorf("unexpected watch timeout %q", q.Get("timeoutSeconds"))
+		}
+		for _, e := range watches[watched] {
+			w.Write([]byte(e + "\n"))
+			w.(http.Flusher).Flush()
+		}
+		watched++
+	}))
+}
+
+func TestListWatch(t *testing.T) {
+	s := watchServer(t,
+		[]string{`{"metadata":{"resourceVersion":"10"},"items":[` + object("a", "1", "5") + `]}`},
+		[][]string{{
+			`{"type":"ADDED","object":` + object("b", "1", "11") + `}`,
+			`{"type":"MODIFIED","object":` + object("a", "2", "12") + `}`,
+			`{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"13"}}}`,
+			`{"type":"DELETED","object":` + object("b", "1", "14") + `}`,
+		}},
+	)
+	defer s.Close()
+	c, err := NewClient(ClientConfig{Server: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := new(recordingStore)
+	// The watch ends when the server closes the stream
+	if err := c.ListWatch(context.Background(), "/api/v1/namespaces/default/configmaps", "app=test", time.Minute, store); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"replace a=1", "update b=1", "update a=2", "delete b"}
+	if !reflect.DeepEqual(store.calls, want) {
+		t.Errorf("got %v, want %v", store.calls, want)
+	}
+}
+
+func TestListWatchResourceVersionExpired(t *testing.T) {
+	s := watchServer(t,
+		[]string{
+			`{"metadata":{"resourceVersion":"10"},"items":[` + object("a", "1", "5") + `]}`,
+			`{"metadata":{"resourceVersion":"20"},"items":[` + object("a", "3", "15") + `]}`,
+		},
+		[][]string{
+			{
+				`{"type":"MODIFIED","object":` + object("a", "2", "11") + `}`,
+				`{"type":"ERROR","object":{"kind":"Status","code":410,"reason":"Expired","message":"too old resource version: 10 (15)"}}`,
+			},
+			{},
+		},
+	)
+	defer s.Close()
+	c, err := NewClient(ClientConfig{Server: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := new(recordingStore)
+	err = c.ListWatch(context.Background(), "/api/v1/namespaces/default/configmaps", "app=test", time.Minute, store)
+	if err == nil || !strings.Contains(err.Error(), "410 Expired") {
+		t.Fatalf("expected a 410 error, got %v", err)
+	}
+	// Listing again restarts the watch from the new resource version
+	if err := c.ListWatch(context.Background(), "/api/v1/namespaces/default/configmaps", "app=test", time.Minute, store); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"replace a=1", "update a=2", "replace a=3"}
+	if !reflect.DeepEqual(store.calls, want) {
+		t.Errorf("got %v, want %v", store.calls, want)
+	}
+}
+
+func TestListWatchCanceled(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "true" {
+			w.Write([]byte(`{"metadata":{"resourceVersion":"1"},"items":[]}`))
+			return
+		}
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer s.Close()
+	defer close(release)
+	c, err := NewClient(ClientConfig{Server: s.URL, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	// The client timeout does not apply to watches
+	err = c.ListWatch(ctx, "/api/v1/namespaces/default/configmaps", "app=test", time.Minute, new(recordingStore))
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected the watch to end with ctx, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := map[string]bool{
+		"":                        false,
+		"abc-123":                 true,
+		"a.b_c:d":                 true,
+		"with space":              false,
+		"line\nbreak":             false,
+		NewRequestID():            true,
+		string(make([]byte, 129)): false,
+	}
+	for id, want := range tests {
+		if got := ValidRequestID(id); got != want {
+			t.Errorf("ValidRequestID(%q) = %t, want %t", id, got, want)
+		}
+	}
+	if a, b := NewRequestID(), NewRequestID(); a == b {
+		t.Errorf("generated request IDs are equal: %s", a)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	params := []string{"code", "state"}
+	tests := map[string]string{
+		"/callback":                      "/callback",
+		"/callback?foo=bar":              "/callback?foo=bar",
+		"/callback?code=secret&foo=bar":  "/callback?code=REDACTED&foo=bar",
+		"/callback?CODE=secret&state=xy": "/callback?CODE=REDACTED&state=REDACTED",
+		"/callback?code=a&code=b":        "/callback?code=REDACTED",
+	}
+	for raw, want := range tests {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := RedactQuery(u, params); got != want {
+			t.Errorf("RedactQuery(%q) = %q, want %q", raw, got, want)
+		}
+		if u.String() != raw {
+			t.Errorf("RedactQuery modified the url: %q", u.String())
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(RequestIDHeader))
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport: &Transport{}}
+	defer client.CloseIdleConnections()
+
+	tests := []struct {
+		ctx    context.Context
+		header string
+		want   string
+	}{
+		{context.Background(), "", ""},
+		{WithRequestID(context.Background(), "from-context"), "", "from-context"},
+		{WithRequestID(context.Background(), "from-context"), "from-header", "from-header"},
+	}
+	for _, tt := range tests {
+		got = nil
+		req, err := http.NewRequestWithContext(tt.ctx, http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set(RequestIDHeader, tt.header)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("request ID header: got %v, want %q", got, tt.want)
+		}
+		if tt.header == "" && req.Header.Get(RequestIDHeader) != "" {
+			t.Error("the transport modified the request headers")
+		}
+	}
+}
+
+func TestContextHook(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.AddHook(ContextHook{})
+
+	logger.WithContext(WithRequestID(context.Background(), "abc")).Info("with ID")
+	logger.WithContext(context.Background()).Info("without ID")
+
+	dec := json.NewDecoder(&buf)
+	for _, want := range []interface{}{"abc", nil} {
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if got := entry[RequestIDField]; got != want {
+			t.Errorf("%s: request ID field is %v, want %v", entry["msg"], got, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces redacted values
+const Redacted = "REDACTED"
+
+// RedactQuery returns u with the values of
+// params in the query string redacted
+func RedactQuery(u *url.URL, params []string) string {
+	r := *u
+	r.RawQuery = RedactRawQuery(u.RawQuery, params)
+	return r.String()
+}
+
+// RedactRawQuery returns the query string rawQuery
+// with the values of params redacted
+func RedactRawQuery(rawQuery string, params []string) string {
+	if rawQuery == "" || len(params) == 0 {
+		return rawQuery
+	}
+	// Invalid pairs are dropped, as by url.URL.Query
+	query, _ := url.ParseQuery(rawQuery)
+	redacted := false
+	for name := range query {
+		for _, p := range params {
+			if strings.EqualFold(name, p) {
+				query[name] = []string{Redacted}
+				redacted = true
+			}
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+	return query.Encode()
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging provides request IDs propagated through
+// contexts, and helpers to keep sensitive data out of logs
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader is the header carrying request IDs
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDField is the log field of request IDs
+const RequestIDField = "request_id"
+
+// requestIDFormat is the format of request IDs accepted
+// from clients, other IDs are replaced by a generated one
+var requestIDFormat = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// ValidRequestID reports if id can be used as request ID
+func ValidRequestID(id string) bool {
+	return requestIDFormat.MatchString(id)
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx carrying id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, if any
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextHook adds the request ID of the entry
+// context to log entries (see logrus.WithContext)
+type ContextHook struct{}
+
+// Levels returns the levels the hook fires for
+func (ContextHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire adds the request ID field to e
+func (ContextHook) Fire(e *log.Entry) error {
+	if id := RequestID(e.Context); id != "" {
+		e.Data[RequestIDField] = id
+	}
+	return nil
+}
+
+// Transport sets the request ID header of outgoing
+// requests from the request context
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	id := RequestID(r.Context())
+	if id == "" || r.Header.Get(RequestIDHeader) != "" {
+		return base.RoundTrip(r)
+	}
+	// RoundTrippers must not modify the request
+	r = r.Clone(r.Context())
+	r.Header.Set(RequestIDHeader, id)
+	return base.RoundTrip(r)
+}
+
+// CloseIdleConnections closes the idle connections of
+// the base transport, see http.Client.CloseIdleConnections
+func (t *Transport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if c, ok := t.Base.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
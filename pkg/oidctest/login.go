@@ -0,0 +1,66 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidctest
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// loginTemplate is the login page, users are
+// selected without password. The authorization
+// request parameters are posted back
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Development OIDC provider</title>
+  <style>
+    body { font-family: sans-serif; max-width: 32em; margin: 4em auto; }
+    button { display: block; width: 100%; margin: 0.5em 0; padding: 0.8em; text-align: left; cursor: pointer; }
+    small { color: #666; }
+  </style>
+</head>
+<body>
+  <h1>Log in as</h1>
+  <form method="post">
+    {{- range $k, $v := .Params }}
+    <input type="hidden" name="{{ $k }}" value="{{ index $v 0 }}">
+    {{- end }}
+    {{- range .Users }}
+    <button type="submit" name="username" value="{{ .Username }}">
+      {{ .Username }}{{ with .Groups }} <small>{{ range $i, $g := . }}{{ if $i }}, {{ end }}{{ $g }}{{ end }}</small>{{ end }}
+    </button>
+    {{- end }}
+    <button type="submit" name="deny" value="true">Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+// renderLogin renders the login page for
+// the authorization request params
+func (p *Provider) renderLogin(w http.ResponseWriter, params url.Values) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	if err := loginTemplate.Execute(w, struct {
+		Params url.Values
+		Users  []User
+	}{params, p.Config.Users}); err != nil {
+		log.Errorf("oidc provider: failed to render login page: %v", err)
+	}
+}
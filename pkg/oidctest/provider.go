@@ -0,0 +1,456 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oidctest provides an in-process OpenID Connect provider,
+// to run loginapp without identity provider in development and tests
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// DefaultClientID is the client ID
+	// accepted by default by the provider
+	DefaultClientID = "loginapp"
+	// DefaultClientSecret is the client secret
+	// accepted by default by the provider
+	DefaultClientSecret = "loginapp-secret"
+	// DefaultTokenTTL is the default lifetime of ID tokens
+	DefaultTokenTTL = time.Hour
+	// codeTTL is the lifetime of authorization codes
+	codeTTL = 5 * time.Minute
+	// crossClientScope is the prefix of scopes requesting
+	// an audience, see Dex cross-client trust
+	crossClientScope = "audience:server:client_id:"
+)
+
+// Config is the provider configuration
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURIs are the allowed redirect
+	// URIs, any URI is allowed if empty
+	RedirectURIs []string
+	// Users are the users of the provider,
+	// DefaultUsers if empty
+	Users []User
+	// AutoLogin is the username of the user
+	// logged in without showing the login page
+	AutoLogin string
+	TokenTTL  time.Duration
+}
+
+// Provider is an OpenID Connect provider supporting the
+// authorization code and refresh token grants, with discovery,
+// JWKS, authorization and token endpoints. Users are not
+// authenticated: they are selected on the login page, with the
+// "login_hint" parameter or with Config.AutoLogin
+type Provider struct {
+	Config Config
+	issuer string
+	key    jose.JSONWebKey
+	signer jose.Signer
+	router *httprouter.Router
+	server *http.Server
+
+	mu            sync.Mutex
+	codes         map[string]authRequest
+	refreshTokens map[string]authRequest
+}
+
+// authRequest is an authorization granted
+// to a client, by code or refresh token
+type authRequest struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	scopes      []string
+	user        User
+	expiry      time.Time
+}
+
+// New returns a provider for issuer, with routes
+// relative to the issuer path. Tokens are signed
+// with a RSA key generated at creation
+func New(issuer string, cfg Config) (*Provider, error) {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid issuer URL %q", issuer)
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = DefaultClientID
+	}
+	if cfg.ClientSecret == "" {
+		cfg.ClientSecret = DefaultClientSecret
+	}
+	if len(cfg.Users) == 0 {
+		cfg.Users = DefaultUsers
+	}
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = DefaultTokenTTL
+	}
+	if cfg.AutoLogin != "" {
+		if _, ok := findUser(cfg.Users, cfg.AutoLogin); !ok {
+			return nil, fmt.Errorf("unknown auto login user %q", cfg.AutoLogin)
+		}
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	kid := sha256.Sum256(rsaKey.PublicKey.N.Bytes())
+	p := &Provider{
+		Config:        cfg,
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		key:           jose.JSONWebKey{Key: rsaKey, KeyID: hex.EncodeToString(kid[:8]), Algorithm: string(jose.RS256), Use: "sig"},
+		router:        httprouter.New(),
+		codes:         make(map[string]authRequest),
+		refreshTokens: make(map[string]authRequest),
+	}
+	if p.signer, err = jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, (&jose.SignerOptions{}).WithType("JWT")); err != nil {
+		return nil, fmt.Errorf("failed to create token signer: %v", err)
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	p.router.GET(base+"/.well-known/openid-configuration", p.handleDiscovery)
+	p.router.GET(base+"/keys", p.handleKeys)
+	p.router.GET(base+"/auth", p.handleAuth)
+	p.router.POST(base+"/auth", p.handleAuth)
+	p.router.POST(base+"/token", p.handleToken)
+	return p, nil
+}
+
+// Start starts a provider listening on address, "127.0.0.1:0"
+// for a random port. The issuer is the listen address, with
+// the loopback address if address is unspecified
+func Start(address string, cfg Config) (*Provider, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	p, err := New("http://"+net.JoinHostPort(host, port), cfg)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	p.server = &http.Server{Handler: p}
+	go func() {
+		if err := p.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("oidc provider stopped: %v", err)
+		}
+	}()
+	log.Infof("oidc provider listening on %s", p.issuer)
+	return p, nil
+}
+
+// Close stops a provider started with Start
+func (p *Provider) Close() error {
+	if p.server == nil {
+		return nil
+	}
+	return p.server.Close()
+}
+
+// Issuer returns the issuer URL of the provider
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// ServeHTTP serves provider endpoints
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.router.ServeHTTP(w, r)
+}
+
+// Configure points an OIDC client configuration at the
+// provider. Certificate validation is disabled, the
+// provider is served over plain HTTP
+func (p *Provider) Configure(cfg *config.OIDC) {
+	cfg.Issuer.URL = p.issuer
+	cfg.Issuer.InsecureSkipVerify = true
+	cfg.Client.ID = p.Config.ClientID
+	cfg.Client.Secret = p.Config.ClientSecret
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/auth",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/keys",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{string(jose.RS256)},
+		"scopes_supported":                      []string{"openid", "profile", "email", "groups", "offline_access"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "preferred_username", "email", "email_verified", "groups"},
+	})
+}
+
+func (p *Provider) handleKeys(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{p.key.Public()}})
+}
+
+// handleAuth serves the authorization endpoint, and the
+// login page form. Errors are returned to the client
+// once client_id and redirect_uri are validated
+func (p *Provider) handleAuth(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("client_id") != p.Config.ClientID {
+		http.Error(w, fmt.Sprintf("unknown client_id %q", r.Form.Get("client_id")), http.StatusBadRequest)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if !p.validRedirectURI(redirectURI) {
+		http.Error(w, fmt.Sprintf("invalid redirect_uri %q", redirectURI), http.StatusBadRequest)
+		return
+	}
+	state := r.Form.Get("state")
+	scopes := strings.Fields(r.Form.Get("scope"))
+	switch {
+	case r.Form.Get("response_type") != "code":
+		redirectError(w, r, redirectURI, state, "unsupported_response_type", "only the authorization code flow is supported")
+		return
+	case !contains(scopes, "openid"):
+		redirectError(w, r, redirectURI, state, "invalid_scope", "the openid scope is required")
+		return
+	case r.Form.Get("deny") != "":
+		redirectError(w, r, redirectURI, state, "access_denied", "the user denied the request")
+		return
+	}
+	username := r.Form.Get("username")
+	if username == "" {
+		username = r.Form.Get("login_hint")
+	}
+	if username == "" {
+		username = p.Config.AutoLogin
+	}
+	if username == "" {
+		p.renderLogin(w, r.Form)
+		return
+	}
+	user, ok := findUser(p.Config.Users, username)
+	if !ok {
+		redirectError(w, r, redirectURI, state, "access_denied", fmt.Sprintf("unknown user %q", username))
+		return
+	}
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		clientID:    p.Config.ClientID,
+		redirectURI: redirectURI,
+		nonce:       r.Form.Get("nonce"),
+		scopes:      scopes,
+		user:        user,
+		expiry:      time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+	log.Debugf("oidc provider: user %q logged in with scopes %v", username, scopes)
+	redirect(w, r, redirectURI, url.Values{"code": {code}, "state": {state}})
+}
+
+// handleToken serves the token endpoint. Clients
+// authenticate with basic auth or form parameters
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		// Credentials are form encoded, see RFC 6749 section 2.3.1
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.Config.ClientID || secret != p.Config.ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	var req authRequest
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "authorization_code":
+		req, ok = take(&p.mu, p.codes, r.PostForm.Get("code"))
+		if !ok || time.Now().After(req.expiry) || req.clientID != clientID || req.redirectURI != r.PostForm.Get("redirect_uri") {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+			return
+		}
+	case "refresh_token":
+		if req, ok = take(&p.mu, p.refreshTokens, r.PostForm.Get("refresh_token")); !ok || req.clientID != clientID {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+			return
+		}
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("unsupported grant type %q", grantType))
+		return
+	}
+	idToken, err := p.idToken(req)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	resp := map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(p.Config.TokenTTL.Seconds()),
+		"id_token":     idToken,
+		"scope":        strings.Join(req.scopes, " "),
+	}
+	if contains(req.scopes, "offline_access") {
+		refreshToken := randomString()
+		// ID tokens issued on refresh have no nonce
+		req.nonce = ""
+		p.mu.Lock()
+		p.refreshTokens[refreshToken] = req
+		p.mu.Unlock()
+		resp["refresh_token"] = refreshToken
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// idToken returns the signed ID token of an authorization
+func (p *Provider) idToken(req authRequest) (string, error) {
+	now := time.Now()
+	claims := req.user.claims(req.scopes)
+	claims["iss"] = p.issuer
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(p.Config.TokenTTL).Unix()
+	claims["jti"] = randomString()
+	claims["aud"] = req.clientID
+	if req.nonce != "" {
+		claims["nonce"] = req.nonce
+	}
+	// Cross client audiences
+	audiences := []string{req.clientID}
+	for _, scope := range req.scopes {
+		if strings.HasPrefix(scope, crossClientScope) {
+			audiences = append(audiences, strings.TrimPrefix(scope, crossClientScope))
+		}
+	}
+	if len(audiences) > 1 {
+		claims["aud"] = audiences
+		claims["azp"] = req.clientID
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %v", err)
+	}
+	jws, err := p.signer.Sign(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign id token: %v", err)
+	}
+	return jws.CompactSerialize()
+}
+
+func (p *Provider) validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() {
+		return false
+	}
+	return len(p.Config.RedirectURIs) == 0 || contains(p.Config.RedirectURIs, uri)
+}
+
+// take returns and removes a single use authorization
+func take(mu *sync.Mutex, m map[string]authRequest, key string) (authRequest, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	req, ok := m[key]
+	delete(m, key)
+	return req, ok
+}
+
+func findUser(users []User, username string) (User, bool) {
+	for _, u := range users {
+		if u.Username == username {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// redirect redirects to uri with params added to its query
+func redirect(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	u, _ := url.Parse(uri)
+	q := u.Query()
+	for k, v := range params {
+		if v[0] != "" {
+			q.Set(k, v[0])
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// redirectError returns an authorization error to the
+// client, see RFC 6749 section 4.1.2.1
+func redirectError(w http.ResponseWriter, r *http.Request, uri string, state string, code string, description string) {
+	redirect(w, r, uri, url.Values{"error": {code}, "error_description": {description}, "state": {state}})
+}
+
+// tokenError returns a token endpoint
+// error, see RFC 6749 section 5.2
+func tokenError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("oidc provider: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidctest
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// User is a user of the provider
+type User struct {
+	// Username is the login name of the user,
+	// and the "preferred_username" claim
+	Username string `yaml:"username"`
+	// Subject is the "sub" claim, Username by default
+	Subject string `yaml:"subject"`
+	// Name is the "name" claim, Username by default
+	Name string `yaml:"name"`
+	// Email is the "email" claim,
+	// "<username>@example.com" by default
+	Email string `yaml:"email"`
+	// Groups is the "groups" claim
+	Groups []string `yaml:"groups"`
+	// Claims are additional claims, they
+	// override claims of the ID token
+	Claims map[string]interface{} `yaml:"claims"`
+}
+
+// DefaultUsers are the users of the provider,
+// when no users are configured
+var DefaultUsers = []User{
+	{Username: "admin", Name: "Admin", Groups: []string{"admins"}},
+	{Username: "developer", Name: "Developer", Groups: []string{"developers"}},
+}
+
+// LoadUsers reads a yaml list of users from file
+func LoadUsers(file string) ([]User, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := yaml.Unmarshal(b, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse users file %q: %v", file, err)
+	}
+	for i, u := range users {
+		if u.Username == "" {
+			return nil, fmt.Errorf("no username specified for user %d in %q", i, file)
+		}
+	}
+	return users, nil
+}
+
+// claims returns the ID token claims
+// of the user for the requested scopes
+func (u User) claims(scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": u.Subject,
+	}
+	if claims["sub"] == "" {
+		claims["sub"] = u.Username
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case "profile":
+			claims["preferred_username"] = u.Username
+			claims["name"] = u.Name
+			if u.Name == "" {
+				claims["name"] = u.Username
+			}
+		case "email":
+			claims["email"] = u.Email
+			if u.Email == "" {
+				claims["email"] = u.Username + "@example.com"
+			}
+			claims["email_verified"] = true
+		case "groups":
+			groups := u.Groups
+			if groups == nil {
+				groups = []string{}
+			}
+			claims["groups"] = groups
+		}
+	}
+	for k, v := range u.Claims {
+		claims[k] = v
+	}
+	return claims
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/kube"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// clusterCATick is the interval between two checks
+	// of certificate authorities to refresh
+	clusterCATick = 30 * time.Second
+)
+
+// clusterCATimeout is the timeout of the certificate
+// authority discovery of a cluster
+var clusterCATimeout = 10 * time.Second
+
+// clusterCA is a certificate authority
+// discovered from a cluster
+type clusterCA struct {
+	pem         string
+	nextRefresh time.Time
+}
+
+// clusterCAs stores discovered certificate authorities,
+// by cluster server and certificate authority hash
+type clusterCAs struct {
+	sync.RWMutex
+	cas map[string]clusterCA
+}
+
+func clusterCAKey(c config.Cluster) string {
+	return c.Server + "|" + c.CACertHash
+}
+
+// Clusters returns the clusters served to users: static clusters
+// followed by clusters discovered from kubernetes objects, including
+// discovered certificate authorities. Static clusters take precedence
+// over discovered clusters with the same name
+func (s *Server) Clusters() []config.Cluster {
+	clusters := make([]config.Cluster, len(s.Config.Clusters))
+	copy(clusters, s.Config.Clusters)
+	names := make(map[string]bool, len(clusters))
+	for _, c := range clusters {
+		names[c.Name] = true
+	}
+	for _, c := range s.discovered.List() {
+		if names[c.Name] {
+			log.Debugf("discovered cluster %q ignored, a static cluster with the same name exists", c.Name)
+			continue
+		}
+		names[c.Name] = true
+		clusters = append(clusters, c)
+	}
+	s.clusterCAs.RLock()
+	defer s.clusterCAs.RUnlock()
+	for i, c := range clusters {
+		if ca, ok := s.clusterCAs.cas[clusterCAKey(c)]; ok && c.DiscoverCA {
+			clusters[i].CertificateAuthority = ca.pem
+		}
+	}
+	return clusters
+}
+
+// RefreshClusterCAs discovers the certificate authority of clusters
+// with discoverCA enabled, once their refresh interval is elapsed.
+// Clusters are discovered concurrently, each within clusterCATimeout.
+// Failed discoveries are logged and retried on next call
+func (s *Server) RefreshClusterCAs(ctx context.Context) {
+	var wg sync.WaitGroup
+	now := time.Now()
+	clusters := append(append([]config.Cluster{}, s.Config.Clusters...), s.discovered.List()...)
+	for _, c := range clusters {
+		if !c.DiscoverCA {
+			continue
+		}
+		key := clusterCAKey(c)
+		s.clusterCAs.RLock()
+		ca, ok := s.clusterCAs.cas[key]
+		s.clusterCAs.RUnlock()
+		if ok && now.Before(ca.nextRefresh) {
+			continue
+		}
+		wg.Add(1)
+		go func(c config.Cluster) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, clusterCATimeout)
+			defer cancel()
+			pem, err := kube.DiscoverCA(ctx, clientConfig(c, "", clusterCATimeout), c.CACertHash)
+			if err != nil {
+				log.Errorf("certificate authority discovery failed for cluster %q: %v", c.Name, err)
+				return
+			}
+			if ok && ca.pem != pem {
+				log.Infof("certificate authority of cluster %q changed", c.Name)
+			}
+			interval := c.CARefreshInterval
+			if interval == 0 {
+				interval = config.DefaultCARefreshInterval
+			}
+			s.clusterCAs.Lock()
+			s.clusterCAs.cas[key] = clusterCA{pem: pem, nextRefresh: now.Add(interval)}
+			s.clusterCAs.Unlock()
+		}(c)
+	}
+	wg.Wait()
+}
+
+// WatchClusterCAs discovers certificate authorities, then
+// refreshes them until ctx is done. Clusters are served
+// without certificate authority until it is discovered
+func (s *Server) WatchClusterCAs(ctx context.Context) {
+	s.RefreshClusterCAs(ctx)
+	ticker := time.NewTicker(clusterCATick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RefreshClusterCAs(ctx)
+		}
+	}
+}
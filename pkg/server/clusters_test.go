@@ -0,0 +1,53 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+func TestRefreshClusterCAsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer unreachable.Close()
+	defer close(release)
+	defer func(timeout time.Duration) { clusterCATimeout = timeout }(clusterCATimeout)
+	clusterCATimeout = 100 * time.Millisecond
+
+	s := New(&config.App{Clusters: []config.Cluster{
+		{Name: "a", Server: unreachable.URL, DiscoverCA: true, CACertHash: "sha256:a"},
+		{Name: "b", Server: unreachable.URL, DiscoverCA: true, CACertHash: "sha256:b"},
+		{Name: "c", Server: unreachable.URL, DiscoverCA: true, CACertHash: "sha256:c"},
+	}})
+	start := time.Now()
+	s.RefreshClusterCAs(context.Background())
+	// Clusters are discovered concurrently, each within the timeout
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("certificate authorities discovery took %s", d)
+	}
+	for _, c := range s.Clusters() {
+		if c.CertificateAuthority != "" {
+			t.Errorf("cluster %q has a certificate authority", c.Name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/claims"
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+// TemplateDataVersion is the version of the template data model.
+// Fields may be added to the model, but fields are never
+// removed or changed without a version bump
+const TemplateDataVersion = "v1"
+
+// TemplateData is the data model exposed to templates,
+// see docs/templates.md
+type TemplateData struct {
+	Version  string
+	User     TemplateUser
+	Clusters []config.Cluster
+	// Namespaces is the default namespace per cluster name
+	Namespaces map[string]string
+	Tokens     TemplateTokens
+	IssuedAt   time.Time
+	Expiry     time.Time
+	Config     TemplateConfig
+	// kubeconfigFor returns the kubeconfig of a cluster
+	kubeconfigFor func(cluster string) (string, error)
+}
+
+// Claim returns the claim of the user found at path, numeric
+// keys index lists (ex: "groups.0"). Missing claims return an
+// error, like the "claim" function of claims templates
+func (d TemplateData) Claim(path string) (interface{}, error) {
+	return d.User.Claims.Value(path)
+}
+
+// KubeconfigFor returns the kubeconfig of the user for a single cluster
+func (d TemplateData) KubeconfigFor(cluster string) (string, error) {
+	if d.kubeconfigFor == nil {
+		return "", fmt.Errorf("unknown cluster %q", cluster)
+	}
+	return d.kubeconfigFor(cluster)
+}
+
+// TemplateUser is the authenticated user
+type TemplateUser struct {
+	// Name is the kubernetes username, with prefix
+	Name   string
+	Groups []string
+	Claims claims.Claims
+}
+
+// TemplateTokens are the tokens issued to the user
+type TemplateTokens struct {
+	IDToken       string
+	IDTokenHeader map[string]interface{}
+	RefreshToken  string
+	// RefreshTokenMAC must be posted with RefreshToken
+	// to download kubeconfigs with a refresh token
+	RefreshTokenMAC string
+	Scopes          []string
+	// ClientSecret is only set when a refresh token is
+	// issued, kubectl requires it to refresh the ID token
+	ClientSecret string
+}
+
+// TemplateConfig is the subset of the configuration
+// exposed to templates, it never includes secrets
+type TemplateConfig struct {
+	Name         string
+	ClientID     string
+	MainClientID string
+	IssuerURL    string
+	AuthCodeOpts map[string]string
+	Kubeconfig   config.WebKubeconfig
+}
+
+// TemplateData returns the template data model of k
+func (s *Server) TemplateData(k KubeUserInfo) TemplateData {
+	d := TemplateData{
+		Version: TemplateDataVersion,
+		User: TemplateUser{
+			Name:   k.UsernameClaim,
+			Groups: k.Groups,
+			Claims: k.Claims,
+		},
+		Clusters:   k.Clusters,
+		Namespaces: k.Namespaces,
+		Tokens: TemplateTokens{
+			IDToken:         k.IDToken,
+			IDTokenHeader:   k.IDTokenHeader,
+			RefreshToken:    k.RefreshToken,
+			RefreshTokenMAC: k.RefreshTokenMAC,
+			Scopes:          k.Scopes,
+		},
+		IssuedAt: k.IssuedAt,
+		Expiry:   k.Expiry,
+		Config: TemplateConfig{
+			Name:         s.Config.Name,
+			ClientID:     s.Config.OIDC.Client.ID,
+			MainClientID: s.Config.Web.MainClientID,
+			IssuerURL:    s.Config.OIDC.Issuer.URL,
+			AuthCodeOpts: s.Config.OIDC.Extra.AuthCodeOpts,
+			Kubeconfig:   s.Config.Web.Kubeconfig,
+		},
+	}
+	if k.RefreshToken != "" {
+		d.Tokens.ClientSecret = s.Config.OIDC.Client.Secret
+	}
+	d.kubeconfigFor = func(name string) (string, error) {
+		for _, c := range k.Clusters {
+			if c.Name == name {
+				b, err := s.Kubeconfig(k, []config.Cluster{c}, c.Name).Marshal()
+				return string(b), err
+			}
+		}
+		return "", fmt.Errorf("unknown cluster %q", name)
+	}
+	return d
+}
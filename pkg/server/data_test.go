@@ -0,0 +1,75 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/fydrah/loginapp/pkg/claims"
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+func TestTemplateDataMethods(t *testing.T) {
+	s := New(&config.App{Name: "test"})
+	k := benchmarkUserInfo(s)
+	tmpl, err := template.New("output").Funcs(TemplateFuncs()).Parse(
+		`{{ .Claim "groups.0" }} {{ .Claim "email" }} {{ .KubeconfigFor "prod" | b64enc | b64dec | len | ne 0 }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	if err := tmpl.Execute(b, k.Data); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "dev jane@example.com true" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestTemplateDataMissingClaim(t *testing.T) {
+	s := New(&config.App{Name: "test"})
+	k := benchmarkUserInfo(s)
+	tmpl := template.Must(template.New("output").Funcs(TemplateFuncs()).Parse(`{{ .Claim "address.country" }}`))
+	err := tmpl.Execute(new(bytes.Buffer), k.Data)
+	if err == nil || !strings.Contains(err.Error(), `claim "address.country" not found`) {
+		t.Errorf("expected a missing claim error, got %v", err)
+	}
+	// Claims templates (web.usernameTemplate, contextName...) fail the same way
+	ct, err := claims.NewTemplate("username", `{{ claim "address.country" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ct.Render(k.Claims); err == nil || !strings.Contains(err.Error(), `claim "address.country" not found`) {
+		t.Errorf("expected a missing claim error, got %v", err)
+	}
+}
+
+func TestTemplateDataKubeconfigFor(t *testing.T) {
+	s := New(&config.App{Name: "test"})
+	k := benchmarkUserInfo(s)
+	kc, err := k.Data.KubeconfigFor("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(kc, "https://staging:6443") || strings.Contains(kc, "https://prod:6443") {
+		t.Errorf("unexpected kubeconfig %s", kc)
+	}
+	if _, err := k.Data.KubeconfigFor("unknown"); err == nil {
+		t.Error("expected an error for an unknown cluster")
+	}
+}
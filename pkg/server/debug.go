@@ -0,0 +1,112 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// Version is the version of loginapp
+type Version struct {
+	GitVersion string `json:"gitVersion"`
+	GitHash    string `json:"gitHash"`
+}
+
+// DebugRoutes setup debug routes on the prometheus
+// router, served by the admin listener
+func (s *Server) DebugRoutes() {
+	s.admin("GET", "/debug/pprof/*name", s.HandleGetPprof)
+	s.admin("POST", "/debug/pprof/symbol", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		pprof.Symbol(w, r)
+	})
+	s.admin("GET", "/debug/config", s.HandleGetDebugConfig)
+	s.admin("GET", "/debug/provider", s.HandleGetDebugProvider)
+	s.admin("GET", "/version", s.HandleGetVersion)
+	log.Debug("debug routes loaded")
+}
+
+// admin registers h for method and path on the prometheus
+// router, with the basic authentication of metrics
+func (s *Server) admin(method string, path string, h httprouter.Handle) {
+	s.promrouter.Handler(method, path, basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, httprouter.ParamsFromContext(r.Context()))
+	}), s.Config.Metrics.BasicAuth))
+}
+
+// HandleGetPprof serves net/http/pprof endpoints
+func (s *Server) HandleGetPprof(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	switch ps.ByName("name") {
+	case "/cmdline":
+		pprof.Cmdline(w, r)
+	case "/profile":
+		pprof.Profile(w, r)
+	case "/symbol":
+		pprof.Symbol(w, r)
+	case "/trace":
+		pprof.Trace(w, r)
+	default:
+		// Index serves the profile named by the path
+		pprof.Index(w, r)
+	}
+}
+
+// HandleGetDebugConfig serves the effective
+// configuration, with secrets redacted
+func (s *Server) HandleGetDebugConfig(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	b, err := config.MarshalYAML(s.Config.Redacted(logging.Redacted), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(b)
+}
+
+// HandleGetDebugProvider serves the OpenID
+// provider metadata discovered by the client
+func (s *Server) HandleGetDebugProvider(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if s.client == nil || s.client.Provider == nil {
+		http.Error(w, "provider not discovered yet", http.StatusServiceUnavailable)
+		return
+	}
+	var metadata map[string]interface{}
+	if err := s.client.Provider.Claims(&metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, metadata)
+}
+
+// HandleGetVersion serves the version of loginapp
+func (s *Server) HandleGetVersion(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, s.Version)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
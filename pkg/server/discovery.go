@@ -0,0 +1,199 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/kube"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DiscoveryDataKey is the ConfigMap or Secret key
+	// containing the cluster description
+	DiscoveryDataKey = "cluster.yaml"
+)
+
+// discoveredClusters stores clusters discovered from
+// kubernetes objects, by object kind and name
+type discoveredClusters struct {
+	sync.RWMutex
+	clusters map[string]map[string]config.Cluster
+}
+
+// List returns discovered clusters, sorted by name
+func (d *discoveredClusters) List() []config.Cluster {
+	var clusters []config.Cluster
+	d.RLock()
+	defer d.RUnlock()
+	for _, objects := range d.clusters {
+		for _, c := range objects {
+			clusters = append(clusters, c)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	return clusters
+}
+
+// clusterStore is a kube.Store decoding clusters
+// from objects of a given kind
+type clusterStore struct {
+	kind      string
+	encoded   bool
+	discovery *discoveredClusters
+}
+
+func (cs *clusterStore) decode(o kube.Object) (config.Cluster, error) {
+	data, ok := o.Data[DiscoveryDataKey]
+	if !ok {
+		return config.Cluster{}, fmt.Errorf("no %q key", DiscoveryDataKey)
+	}
+	raw := []byte(data)
+	if cs.encoded {
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(data); err != nil {
+			return config.Cluster{}, err
+		}
+	}
+	c, err := config.DecodeCluster(raw)
+	if err != nil {
+		return c, err
+	}
+	if c.Name == "" {
+		c.Name = o.Metadata.Name
+	}
+	// Local files are not allowed for clusters
+	// discovered from kubernetes objects
+	c.CertificateAuthorityFile = ""
+	c.Kubeconfig = ""
+	return c, c.LoadCertificateAuthority()
+}
+
+// Replace implements kube.Store
+func (cs *clusterStore) Replace(objects []kube.Object) {
+	clusters := make(map[string]config.Cluster, len(objects))
+	for _, o := range objects {
+		c, err := cs.decode(o)
+		if err != nil {
+			log.Errorf("invalid cluster in %s %s/%s: %v", cs.kind, o.Metadata.Namespace, o.Metadata.Name, err)
+			continue
+		}
+		clusters[o.Metadata.Name] = c
+	}
+	cs.discovery.Lock()
+	cs.discovery.clusters[cs.kind] = clusters
+	cs.discovery.Unlock()
+	log.Debugf("%d clusters discovered from %s objects", len(clusters), cs.kind)
+}
+
+// Update implements kube.Store
+func (cs *clusterStore) Update(o kube.Object) {
+	c, err := cs.decode(o)
+	if err != nil {
+		log.Errorf("invalid cluster in %s %s/%s: %v", cs.kind, o.Metadata.Namespace, o.Metadata.Name, err)
+		cs.Delete(o)
+		return
+	}
+	cs.discovery.Lock()
+	defer cs.discovery.Unlock()
+	if cs.discovery.clusters[cs.kind] == nil {
+		cs.discovery.clusters[cs.kind] = make(map[string]config.Cluster)
+	}
+	cs.discovery.clusters[cs.kind][o.Metadata.Name] = c
+	log.Infof("cluster %q discovered from %s %s/%s", c.Name, cs.kind, o.Metadata.Namespace, o.Metadata.Name)
+}
+
+// Delete implements kube.Store
+func (cs *clusterStore) Delete(o kube.Object) {
+	cs.discovery.Lock()
+	defer cs.discovery.Unlock()
+	if _, ok := cs.discovery.clusters[cs.kind][o.Metadata.Name]; ok {
+		delete(cs.discovery.clusters[cs.kind], o.Metadata.Name)
+		log.Infof("cluster from %s %s/%s removed", cs.kind, o.Metadata.Namespace, o.Metadata.Name)
+	}
+}
+
+// WatchClusters discovers clusters from ConfigMaps and Secrets
+// matching discovery.labelSelector, until ctx is done
+func (s *Server) WatchClusters(ctx context.Context) {
+	for _, kind := range []string{"configmaps", "secrets"} {
+		go s.watchClusters(ctx, &clusterStore{
+			kind:      kind,
+			encoded:   kind == "secrets",
+			discovery: &s.discovered,
+		})
+	}
+}
+
+func (s *Server) watchClusters(ctx context.Context, store *clusterStore) {
+	var (
+		// kc is reused across listings, and replaced
+		// when the API server configuration changes
+		kc    *kube.Client
+		kcCfg kube.ClientConfig
+	)
+	defer func() {
+		if kc != nil {
+			kc.HTTPClient.CloseIdleConnections()
+		}
+	}()
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0
+	for ctx.Err() == nil {
+		start := time.Now()
+		// The service account token is read again at each
+		// listing, since bound tokens are rotated by the kubelet
+		cfg, namespace, err := kube.InClusterConfig()
+		if err == nil {
+			if s.Config.Discovery.Namespace != "" {
+				namespace = s.Config.Discovery.Namespace
+			}
+			token := cfg.BearerToken
+			cfg.BearerToken = ""
+			if kc == nil || cfg != kcCfg {
+				if kc != nil {
+					kc.HTTPClient.CloseIdleConnections()
+				}
+				kc, err = kube.NewClient(cfg)
+				kcCfg = cfg
+			}
+			if err == nil {
+				collection := fmt.Sprintf("/api/v1/namespaces/%s/%s", namespace, store.kind)
+				err = kc.WithBearerToken(token).ListWatch(ctx, collection, s.Config.Discovery.LabelSelector, s.Config.Discovery.ResyncPeriod, store)
+			} else {
+				kc = nil
+			}
+		}
+		if err == nil || time.Since(start) > s.Config.Discovery.ResyncPeriod {
+			b.Reset()
+		}
+		if err != nil && ctx.Err() == nil {
+			wait := b.NextBackOff()
+			log.Errorf("clusters discovery from %s failed, retrying in %v: %v", store.kind, wait, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}
+}
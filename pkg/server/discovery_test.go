@@ -0,0 +1,123 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/kube"
+)
+
+// discoveryObject returns an object describing a cluster
+func discoveryObject(name string, data string) kube.Object {
+	var o kube.Object
+	o.Metadata.Name = name
+	o.Metadata.Namespace = "loginapp"
+	o.Data = map[string]string{DiscoveryDataKey: data}
+	return o
+}
+
+func clusterNames(clusters []config.Cluster) []string {
+	var names []string
+	for _, c := range clusters {
+		names = append(names, c.Name+"="+c.Server)
+	}
+	return names
+}
+
+func TestClusterStore(t *testing.T) {
+	d := &discoveredClusters{clusters: make(map[string]map[string]config.Cluster)}
+	configmaps := &clusterStore{kind: "configmaps", discovery: d}
+	secrets := &clusterStore{kind: "secrets", encoded: true, discovery: d}
+
+	configmaps.Replace([]kube.Object{
+		discoveryObject("prod", "server: https://prod:6443\ncertificate-authority-file: /etc/passwd\n"),
+		discoveryObject("named", "name: staging\nserver: https://staging:6443\n"),
+		discoveryObject("invalid", "server: [\n"),
+		{},
+	})
+	secrets.Replace([]kube.Object{
+		discoveryObject("dev", base64.StdEncoding.EncodeToString([]byte("server: https://dev:6443\n"))),
+		// Secret data is base64 encoded
+		discoveryObject("plain", "server: https://plain:6443\n"),
+	})
+	if got, want := clusterNames(d.List()), []string{"dev=https://dev:6443", "prod=https://prod:6443", "staging=https://staging:6443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, c := range d.List() {
+		if c.CertificateAuthorityFile != "" || c.CertificateAuthority != "" {
+			t.Errorf("cluster %q loaded a local file", c.Name)
+		}
+	}
+
+	configmaps.Update(discoveryObject("prod", "server: https://prod2:6443\n"))
+	configmaps.Update(discoveryObject("qa", "server: https://qa:6443\n"))
+	secrets.Delete(discoveryObject("dev", ""))
+	// An invalid update removes the cluster
+	configmaps.Update(discoveryObject("named", "server: [\n"))
+	if got, want := clusterNames(d.List()), []string{"prod=https://prod2:6443", "qa=https://qa:6443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClusterStoreListWatch(t *testing.T) {
+	encoded := func(server string) string {
+		return base64.StdEncoding.EncodeToString([]byte("server: " + server + "\n"))
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		if r.URL.Query().Get("watch") != "true" {
+			enc.Encode(map[string]interface{}{
+				"metadata": map[string]string{"resourceVersion": "1"},
+				"items":    []kube.Object{discoveryObject("prod", encoded("https://prod:6443"))},
+			})
+			return
+		}
+		for _, e := range []struct {
+			t string
+			o kube.Object
+		}{
+			{kube.EventAdded, discoveryObject("dev", encoded("https://dev:6443"))},
+			{kube.EventModified, discoveryObject("prod", encoded("https://prod2:6443"))},
+			{kube.EventDeleted, discoveryObject("dev", "")},
+		} {
+			object, _ := json.Marshal(e.o)
+			enc.Encode(kube.Event{Type: e.t, Object: object})
+		}
+	}))
+	defer api.Close()
+
+	s := New(&config.App{Clusters: []config.Cluster{{Name: "static", Server: "https://static:6443"}}})
+	kc, err := kube.NewClient(kube.ClientConfig{Server: api.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &clusterStore{kind: "secrets", encoded: true, discovery: &s.discovered}
+	if err := kc.ListWatch(context.Background(), "/api/v1/namespaces/loginapp/secrets", "loginapp.fydrah.com/cluster=true", time.Minute, store); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clusterNames(s.Clusters()), []string{"static=https://static:6443", "prod=https://prod2:6443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorType is the type of an error reported to users,
+// it is used as key of the web.errors configuration
+type ErrorType string
+
+// Error types
+const (
+	// ErrIDPDenied: the IdP returned an error to the callback
+	ErrIDPDenied ErrorType = "idp_denied"
+	// ErrInvalidRequest: a required parameter is missing or invalid
+	ErrInvalidRequest ErrorType = "invalid_request"
+	// ErrStateMismatch: the callback state does not match the
+	// state sent to the IdP (CSRF protection)
+	ErrStateMismatch ErrorType = "state_mismatch"
+	// ErrCodeExchange: the authorization code cannot be
+	// exchanged for tokens
+	ErrCodeExchange ErrorType = "code_exchange_failed"
+	// ErrInvalidToken: the ID token is invalid or expired
+	ErrInvalidToken ErrorType = "invalid_token"
+	// ErrMissingUsername: the username cannot be built
+	// from the ID token claims
+	ErrMissingUsername ErrorType = "missing_username_claim"
+	// ErrInternal: any other error
+	ErrInternal ErrorType = "internal_error"
+)
+
+// errorTypes describes error types: status code,
+// title and default user guidance
+var errorTypes = map[ErrorType]struct {
+	status int
+	title  string
+	help   string
+}{
+	ErrIDPDenied:       {http.StatusForbidden, "Login denied by the identity provider", "The identity provider refused to authenticate you. Try to login again, or contact your administrator if the problem persists."},
+	ErrInvalidRequest:  {http.StatusBadRequest, "Invalid request", "The request is incomplete or malformed. Start a new login from the home page."},
+	ErrStateMismatch:   {http.StatusBadRequest, "Login session mismatch", "The login response does not match your login request. Start a new login from the home page, using the same browser from start to end."},
+	ErrCodeExchange:    {http.StatusBadGateway, "Unable to retrieve tokens", "The identity provider did not deliver tokens for this login, the login may have expired. Start a new login from the home page."},
+	ErrInvalidToken:    {http.StatusUnauthorized, "Invalid or expired token", "Your token is invalid or has expired. Start a new login from the home page."},
+	ErrMissingUsername: {http.StatusForbidden, "Missing username", "Your identity does not provide the information used as kubernetes username. Contact your administrator."},
+	ErrInternal:        {http.StatusInternalServerError, "Internal server error", "An unexpected error occurred. Contact your administrator with the request ID below if the problem persists."},
+}
+
+// Error is an error reported to users
+type Error struct {
+	Type ErrorType
+	// Description is displayed to users, it must not
+	// contain sensitive information
+	Description string
+	// Err is the cause, logged but never displayed
+	Err error
+}
+
+// NewError returns an error of type t
+func NewError(t ErrorType, description string, err error) *Error {
+	return &Error{Type: t, Description: description, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := string(e.Type)
+	if e.Description != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Description)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the http status code of the error
+func (e *Error) Status() int {
+	if t, ok := errorTypes[e.Type]; ok {
+		return t.status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorPage is the data of the error template
+type ErrorPage struct {
+	AppConfig   *config.App
+	Status      int
+	Type        ErrorType
+	Title       string
+	Description string
+	RequestID   string
+	Help        string
+	Links       []config.WebLink
+}
+
+// CheckErrorsConfig returns an error if web.errors
+// contains unknown error types
+func CheckErrorsConfig(errs map[string]config.WebError) error {
+	for t := range errs {
+		if _, ok := errorTypes[ErrorType(t)]; !ok {
+			known := make([]string, 0, len(errorTypes))
+			for k := range errorTypes {
+				known = append(known, string(k))
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown error type %q in web.errors, must be one of %v", t, known)
+		}
+	}
+	return nil
+}
+
+// RenderError logs err and renders the error page. Errors
+// which are not of type *Error are reported as internal errors
+func (s *Server) RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = NewError(ErrInternal, "", err)
+	}
+	requestID := logging.RequestID(r.Context())
+	log.WithContext(r.Context()).Errorf("%s %s: %v", r.Method, r.URL.Path, e)
+	page := ErrorPage{
+		AppConfig:   s.Config,
+		Status:      e.Status(),
+		Type:        e.Type,
+		Title:       errorTypes[e.Type].title,
+		Description: e.Description,
+		RequestID:   requestID,
+		Help:        errorTypes[e.Type].help,
+	}
+	if guidance, ok := s.Config.Web.Errors[string(e.Type)]; ok {
+		if guidance.Help != "" {
+			page.Help = guidance.Help
+		}
+		page.Links = guidance.Links
+	}
+	b := s.bufpool.Get()
+	defer s.bufpool.Put(b)
+	tmpl, tErr := s.templates.Get("error")
+	if tErr == nil {
+		tErr = tmpl.Execute(b, page)
+	}
+	if tErr != nil {
+		log.WithContext(r.Context()).Errorf("error rendering error template: %v", tErr)
+		http.Error(w, fmt.Sprintf("%s (request ID: %s)", page.Title, requestID), page.Status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(page.Status)
+	b.WriteTo(w)
+}
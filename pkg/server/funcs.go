@@ -0,0 +1,203 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strings"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// TemplateFuncs returns the functions available in templates.
+// Functions bound to the user being rendered ("claim" and
+// "kubeconfigFor") take the data model as last argument, so
+// that templates are parsed once with the same functions
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Strings
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"indent":     indent,
+		"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+		"quote":      func(s string) string { return fmt.Sprintf("%q", s) },
+		"squote":     squote,
+		"shellQuote": shellQuote,
+		"default":    defaultValue,
+		// Encoding
+		"toYaml":       toYAML,
+		"toJson":       toJSON,
+		"toPrettyJson": toPrettyJSON,
+		"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":       b64dec,
+		// User bound
+		"claim":         claim,
+		"kubeconfigFor": kubeconfigFor,
+	}
+}
+
+// title upper cases the first letter of every word
+func title(s string) string {
+	return cases.Title(language.Und, cases.NoLower).String(s)
+}
+
+// squote single quotes s, embedded single
+// quotes are doubled like in yaml
+func squote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// templateData returns the data model of data, the template
+// root: the data model itself in outputs, the user info in
+// the token page
+func templateData(data interface{}) (TemplateData, error) {
+	switch d := data.(type) {
+	case TemplateData:
+		return d, nil
+	case *TemplateData:
+		return *d, nil
+	case KubeUserInfo:
+		return d.Data, nil
+	case *KubeUserInfo:
+		return d.Data, nil
+	}
+	return TemplateData{}, fmt.Errorf("expected the template data model, got %T", data)
+}
+
+// claim returns the claim of the user found at path
+// (ex: {{ claim "groups.0" .Data }}), see TemplateData.Claim
+func claim(path string, data interface{}) (interface{}, error) {
+	d, err := templateData(data)
+	if err != nil {
+		return nil, err
+	}
+	return d.Claim(path)
+}
+
+// kubeconfigFor returns the kubeconfig of the user for a single
+// cluster (ex: {{ kubeconfigFor "prod" .Data }}), see
+// TemplateData.KubeconfigFor
+func kubeconfigFor(cluster string, data interface{}) (string, error) {
+	d, err := templateData(data)
+	if err != nil {
+		return "", err
+	}
+	return d.KubeconfigFor(cluster)
+}
+
+// shellQuote quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func join(sep string, v interface{}) string {
+	switch l := v.(type) {
+	case []string:
+		return strings.Join(l, sep)
+	case []interface{}:
+		s := make([]string, len(l))
+		for i, item := range l {
+			s[i] = fmt.Sprint(item)
+		}
+		return strings.Join(s, sep)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// defaultValue returns def if v is empty
+func defaultValue(def interface{}, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	if rv := reflect.ValueOf(v); rv.IsZero() || ((rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0) {
+		return def
+	}
+	return v
+}
+
+// toYAML renders structs with configuration keys,
+// like "defaultNamespace"
+func toYAML(v interface{}) (string, error) {
+	b, err := config.MarshalYAML(v, false)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(jsonCompatible(v))
+	return string(b), err
+}
+
+func toPrettyJSON(v interface{}) (string, error) {
+	b, err := json.MarshalIndent(jsonCompatible(v), "", "  ")
+	return string(b), err
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
+
+// jsonCompatible converts yaml decoded maps
+// (map[interface{}]interface{}) to json compatible maps
+func jsonCompatible(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[k] = jsonCompatible(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for i, item := range value {
+			l[i] = jsonCompatible(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+func TestSquote(t *testing.T) {
+	tests := map[string]string{
+		"":        "''",
+		"jane":    "'jane'",
+		"o'brien": "'o''brien'",
+		"''":      "''''''",
+		`a"b $c`:  `'a"b $c'`,
+	}
+	for in, want := range tests {
+		if got := squote(in); got != want {
+			t.Errorf("squote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTitle(t *testing.T) {
+	tests := map[string]string{
+		"":                "",
+		"jane doe":        "Jane Doe",
+		"kubernetes AUTH": "Kubernetes AUTH",
+		"o'brien-smith":   "O'brien-Smith",
+		"élodie":          "Élodie",
+	}
+	for in, want := range tests {
+		if got := title(in); got != want {
+			t.Errorf("title(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTemplateFuncsUserBound(t *testing.T) {
+	s := New(&config.App{Name: "test"})
+	k := benchmarkUserInfo(s)
+
+	// Outputs are executed with the data model as root
+	output := template.Must(template.New("output").Funcs(TemplateFuncs()).Parse(
+		`{{ claim "groups.0" . }} {{ . | claim "email" }} {{ contains "https://staging:6443" (kubeconfigFor "staging" .) }}`))
+	b := new(bytes.Buffer)
+	if err := output.Execute(b, k.Data); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "dev jane@example.com true"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The token page is executed with the user info as root
+	page := htmltemplate.Must(htmltemplate.New("token").Funcs(htmltemplate.FuncMap(TemplateFuncs())).Parse(
+		`{{ claim "groups.0" .Data }} {{ claim "groups.0" . }}`))
+	b.Reset()
+	if err := page.Execute(b, k); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "dev dev"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for text, want := range map[string]string{
+		`{{ claim "address.country" . }}`: `claim "address.country" not found`,
+		`{{ kubeconfigFor "unknown" . }}`: `unknown cluster "unknown"`,
+		`{{ claim "email" "jane" }}`:      `expected the template data model, got string`,
+	} {
+		tmpl := template.Must(template.New("output").Funcs(TemplateFuncs()).Parse(text))
+		if err := tmpl.Execute(new(bytes.Buffer), k.Data); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error %q, got %v", text, want, err)
+		}
+	}
+}
@@ -16,13 +16,13 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"html/template"
-	"io/ioutil"
+	"io/fs"
 	"net/http"
-	"os"
 
-	"github.com/gobuffalo/packr/v2"
+	"github.com/fydrah/loginapp/pkg/tracing"
+	"github.com/fydrah/loginapp/web"
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
 )
@@ -42,58 +42,49 @@ func (s *Server) HandleGetHealthz(w http.ResponseWriter, r *http.Request, _ http
 
 // HandleLogin redirects client to the IdP
 func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "HandleLogin")
+	defer span.End()
+	r = r.WithContext(ctx)
+	s.loginMetrics.started.WithLabelValues(s.Config.OIDC.Issuer.URL).Inc()
 	http.Redirect(w, r, s.client.AuthCodeURL(r, s.Config.Secret), http.StatusSeeOther)
 }
 
-// GetTemplateStrFromPackr returns string representation of a template from Packr
-func GetTemplateStrFromPackr(templateName string) (string, error) {
-	tBox := packr.New("templates", "../../web/templates")
-	// Get the string representation of a file, or an error if it doesn't exist:
-	tmpl, err := tBox.FindString(fmt.Sprintf("%v.html", templateName))
+// GetTemplateStr returns string representation of a template,
+// from templatesDir if found, from embedded templates otherwise
+func GetTemplateStr(templatesDir string, templateName string) (string, error) {
+	tmpl, err := fs.ReadFile(web.Layered(templatesDir, web.Templates()), fmt.Sprintf("%v.html", templateName))
 	if err != nil {
 		log.Errorf("template loading failed: %v", err)
 		return "", err
 	}
-	return tmpl, nil
-}
-
-// GetTemplateStrFromFile returns string representation of a template from file
-func GetTemplateStrFromFile(fileName string) (string, error) {
-	// Read the string representation of a file, or an error if it can not be read:
-	tmpl, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		log.Errorf("template loading from file failed: %v", err)
-		return "", err
-	}
 	return string(tmpl), nil
 }
 
-// GetTemplateStr returns string representation of a template
-func (s *Server) GetTemplateStr(templateName string) (string, error) {
-	tmplFileName := fmt.Sprintf("%v/%v.html", s.Config.Web.TemplatesDir, templateName)
-	tmplFile, err := os.Stat(tmplFileName)
-	if err != nil || !tmplFile.Mode().IsRegular() {
-		return GetTemplateStrFromPackr(templateName)
-	} else {
-		return GetTemplateStrFromFile(tmplFileName)
-	}
-}
-
 // HandleGetCallback serves callback requests from the IdP
 func (s *Server) HandleGetCallback(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	kc, err := s.ProcessCallback(w, r)
+	ctx, span := tracing.Tracer().Start(r.Context(), "HandleGetCallback")
+	defer span.End()
+	r = r.WithContext(ctx)
+	kc, err := s.ProcessCallback(r)
 	if err != nil {
-		log.Errorf("error handling callback: %v", err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		tracing.RecordError(span, err)
+		reason := ErrInternal
+		var e *Error
+		if errors.As(err, &e) {
+			reason = e.Type
+		}
+		s.loginMetrics.failed.WithLabelValues(s.Config.OIDC.Issuer.URL, string(reason)).Inc()
+		s.RenderError(w, r, err)
 		return
 	}
-
-	tokenTmplStr, err := s.GetTemplateStr("token")
+	s.loginMetrics.completed.WithLabelValues(s.Config.OIDC.Issuer.URL).Inc()
+	if kc.RefreshToken != "" {
+		s.loginMetrics.refresh.WithLabelValues(s.Config.OIDC.Issuer.URL).Inc()
+	}
+	tokenTmpl, err := s.templates.Get("token")
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		s.RenderError(w, r, err)
 		return
 	}
-	var tokenTmpl = template.New("token")
-	tokenTmpl.Parse(tokenTmplStr)
-	s.RenderTemplate(w, tokenTmpl, kc)
+	s.RenderTemplate(w, r, tokenTmpl, kc)
 }
@@ -16,16 +16,68 @@
 package server
 
 import (
+	"encoding/json"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/claims"
 	"github.com/fydrah/loginapp/pkg/config"
 )
 
 // KubeUserInfo contains required user information
 // for OIDC authentication
 type KubeUserInfo struct {
-	IDToken       string
-	RefreshToken  string
-	RedirectURL   string
-	Claims        interface{}
-	UsernameClaim string
-	AppConfig     *config.App
+	IDToken      string
+	RefreshToken string
+	// RefreshTokenMAC binds RefreshToken to IDToken, it is
+	// posted back with tokens for kubeconfig downloads
+	RefreshTokenMAC string
+	RedirectURL     string
+	Claims          claims.Claims
+	UsernameClaim   string
+	AppConfig       *config.App
+	// Clusters served to the user, AppConfig.Clusters
+	// with discovered certificate authorities
+	Clusters []config.Cluster
+	// Identity information, mainly used to help users
+	// to diagnose RBAC issues
+	IDTokenHeader map[string]interface{}
+	Groups        []string
+	Scopes        []string
+	IssuedAt      time.Time
+	Expiry        time.Time
+	// Permissions is the RBAC preview of clusters
+	// with rbacPreview enabled
+	Permissions []ClusterPermissions
+	// Kubeconfig is the full kubeconfig, for every cluster
+	Kubeconfig string
+	// Namespaces is the default namespace per cluster name
+	Namespaces map[string]string
+	// Data is the versioned template data model, custom
+	// templates should rely on it rather than on other fields
+	Data TemplateData
+	// Outputs are the rendered custom outputs (web.outputs)
+	Outputs []RenderedOutput
+	// Snippets are the kubectl snippets per shell,
+	// Shell is the shell selected by the user
+	Snippets []ShellSnippets
+	Shell    string
+}
+
+// ClaimsJSON returns claims as an indented json document
+func (k KubeUserInfo) ClaimsJSON() string {
+	return indentJSON(k.Claims)
+}
+
+// IDTokenHeaderJSON returns the ID token header
+// as an indented json document
+func (k KubeUserInfo) IDTokenHeaderJSON() string {
+	return indentJSON(k.IDTokenHeader)
+}
+
+func indentJSON(v interface{}) string {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
 }
@@ -0,0 +1,262 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/fydrah/loginapp/pkg/claims"
+	"github.com/fydrah/loginapp/pkg/client"
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/kube"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+var filenameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Kubeconfig returns the kubeconfig of a user for a list of clusters.
+// current is the name of the cluster used as current context, the
+// default context (web.kubeconfig.defaultContext or defaultCluster)
+// is used if empty
+func (s *Server) Kubeconfig(k KubeUserInfo, clusters []config.Cluster, current string) *kube.Kubeconfig {
+	kc := kube.NewKubeconfig()
+	for _, c := range clusters {
+		kc.Contexts = append(kc.Contexts, kube.NamedContext{
+			Name: s.ContextName(c, k.UsernameClaim, k.Claims),
+			Context: kube.Context{
+				User:      k.UsernameClaim,
+				Cluster:   c.Name,
+				Namespace: s.Namespace(c, k.Claims),
+			},
+		})
+		kc.Clusters = append(kc.Clusters, kube.NamedCluster{
+			Name: c.Name,
+			Cluster: kube.Cluster{
+				Server:                   c.Server,
+				TLSServerName:            c.TLSServerName,
+				CertificateAuthorityData: c.Base64Cert(),
+				InsecureSkipTLSVerify:    c.InsecureSkipTLSVerify,
+				ProxyURL:                 c.ProxyURL,
+				DisableCompression:       c.DisableCompression,
+				Extensions:               kube.NewExtensions(c.Extensions),
+			},
+		})
+		if c.Name == current {
+			kc.CurrentContext = s.ContextName(c, k.UsernameClaim, k.Claims)
+		}
+	}
+	if kc.CurrentContext == "" {
+		kc.CurrentContext = s.defaultContext(clusters, k.UsernameClaim, k.Claims)
+	}
+	authProviderConfig := map[string]string{
+		"client-id": s.Config.OIDC.Client.ID,
+		"id-token":  k.IDToken,
+	}
+	for key, value := range s.Config.Web.Kubeconfig.ExtraOpts {
+		authProviderConfig[key] = value
+	}
+	if iss, err := k.Claims.String("iss"); err == nil {
+		authProviderConfig["idp-issuer-url"] = iss
+	}
+	if k.RefreshToken != "" {
+		authProviderConfig["client-secret"] = s.Config.OIDC.Client.Secret
+		authProviderConfig["refresh-token"] = k.RefreshToken
+	}
+	kc.Users = []kube.NamedUser{{
+		Name: k.UsernameClaim,
+		User: kube.User{
+			AuthProvider: &kube.AuthProvider{
+				Name:   "oidc",
+				Config: authProviderConfig,
+			},
+		},
+	}}
+	return kc
+}
+
+// Namespace returns the default namespace of a cluster for a user.
+// The cluster defaultNamespace template is used if set, and falls
+// back to web.kubeconfig.defaultNamespace
+func (s *Server) Namespace(c config.Cluster, cl claims.Claims) string {
+	for _, nsTmpl := range []string{c.DefaultNamespace, s.Config.Web.Kubeconfig.DefaultNamespace} {
+		if nsTmpl == "" {
+			continue
+		}
+		ns, err := s.claimsTemplates.Render("namespace", nsTmpl, cl)
+		if err != nil || ns == "" {
+			log.Warningf("failed to render namespace template %q for cluster %q: %v", nsTmpl, c.Name, err)
+			continue
+		}
+		return ns
+	}
+	return ""
+}
+
+// ContextName returns the kubeconfig context name of a cluster
+// for a user. The cluster contextName template is used if set,
+// and falls back to "<cluster>/<username>"
+func (s *Server) ContextName(c config.Cluster, username string, cl claims.Claims) string {
+	if c.ContextName != "" {
+		name, err := s.claimsTemplates.Render("context", c.ContextName, cl)
+		if err == nil && name != "" {
+			return name
+		}
+		log.Warningf("failed to render context name template %q for cluster %q: %v", c.ContextName, c.Name, err)
+	}
+	return c.DefaultContextName(username)
+}
+
+// claimsTemplates caches claims templates of clusters,
+// parsed once per template text. Clusters discovered from
+// kubernetes objects are not parsed at configuration load
+type claimsTemplates struct {
+	sync.Mutex
+	templates map[string]*claims.Template
+}
+
+// Render renders the claims template text against cl
+func (t *claimsTemplates) Render(name string, text string, cl claims.Claims) (string, error) {
+	t.Lock()
+	tmpl, ok := t.templates[text]
+	if !ok {
+		var err error
+		if tmpl, err = claims.NewTemplate(name, text); err != nil {
+			t.Unlock()
+			return "", err
+		}
+		if t.templates == nil {
+			t.templates = make(map[string]*claims.Template)
+		}
+		t.templates[text] = tmpl
+	}
+	t.Unlock()
+	return tmpl.Render(cl)
+}
+
+// Namespaces returns the default namespace of every cluster for a user
+func (s *Server) Namespaces(clusters []config.Cluster, cl claims.Claims) map[string]string {
+	namespaces := make(map[string]string, len(clusters))
+	for _, c := range clusters {
+		namespaces[c.Name] = s.Namespace(c, cl)
+	}
+	return namespaces
+}
+
+// defaultContext returns the current context of a kubeconfig:
+// web.kubeconfig.defaultContext if set, the context of
+// web.kubeconfig.defaultCluster otherwise
+func (s *Server) defaultContext(clusters []config.Cluster, username string, cl claims.Claims) string {
+	if s.Config.Web.Kubeconfig.DefaultContext != "" {
+		return s.Config.Web.Kubeconfig.DefaultContext
+	}
+	for _, c := range clusters {
+		if c.Name == s.Config.Web.Kubeconfig.DefaultCluster {
+			return s.ContextName(c, username, cl)
+		}
+	}
+	// Default cluster may be unknown when clusters
+	// are discovered from kubernetes objects
+	if len(clusters) > 0 {
+		return s.ContextName(clusters[0], username, cl)
+	}
+	return fmt.Sprintf("%s/%s", s.Config.Web.Kubeconfig.DefaultCluster, username)
+}
+
+// FullKubeconfig returns the kubeconfig of a user for every cluster
+func (s *Server) FullKubeconfig(k KubeUserInfo) (string, error) {
+	b, err := s.Kubeconfig(k, k.Clusters, "").Marshal()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// HandlePostKubeconfig serves kubeconfig downloads for a
+// selection of clusters. The request form must provide:
+//   - id_token: the ID token issued during the callback
+//   - refresh_token: the refresh token, if any
+//   - refresh_token_mac: the MAC of the refresh token, issued
+//     during the callback (see client.RefreshTokenMAC)
+//   - cluster: one or more cluster names
+//   - current: cluster name to use as current context (optional,
+//     defaults to the first selected cluster)
+func (s *Server) HandlePostKubeconfig(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := r.ParseForm(); err != nil {
+		s.RenderError(w, r, NewError(ErrInvalidRequest, "invalid form", err))
+		return
+	}
+	rawIDToken := r.PostFormValue("id_token")
+	idToken, err := s.client.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		s.RenderError(w, r, NewError(ErrInvalidToken, "", err))
+		return
+	}
+	jsonClaims, err := client.ExtractClaims(idToken)
+	if err != nil {
+		s.RenderError(w, r, err)
+		return
+	}
+	username, err := s.Username(jsonClaims)
+	if err != nil {
+		s.RenderError(w, r, NewError(ErrMissingUsername, "", err))
+		return
+	}
+	// The client secret is only added to kubeconfigs
+	// with a refresh token issued during the callback
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken != "" && !client.VerifyRefreshTokenMAC(rawIDToken, refreshToken, r.PostFormValue("refresh_token_mac"), s.Config.Secret) {
+		s.RenderError(w, r, NewError(ErrInvalidRequest, "invalid refresh token", fmt.Errorf("refresh token MAC mismatch")))
+		return
+	}
+	var selected []config.Cluster
+	for _, name := range r.PostForm["cluster"] {
+		for _, c := range s.Clusters() {
+			if c.Name == name {
+				selected = append(selected, c)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		s.RenderError(w, r, NewError(ErrInvalidRequest, "no known cluster selected", nil))
+		return
+	}
+	current := r.PostFormValue("current")
+	if current == "" {
+		current = selected[0].Name
+	}
+	kc := s.Kubeconfig(KubeUserInfo{
+		IDToken:       rawIDToken,
+		RefreshToken:  refreshToken,
+		Claims:        jsonClaims,
+		UsernameClaim: username,
+	}, selected, current)
+	b, err := kc.Marshal()
+	if err != nil {
+		s.RenderError(w, r, err)
+		return
+	}
+	filename := "kubeconfig.yaml"
+	if len(selected) == 1 {
+		filename = fmt.Sprintf("kubeconfig-%s.yaml", filenameUnsafeChars.ReplaceAllString(selected[0].Name, "_"))
+	}
+	w.Header().Set("Content-Type", "application/yaml; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b)
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/claims"
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+func TestContextName(t *testing.T) {
+	s := New(&config.App{})
+	cl := claims.Claims{"preferred_username": "jane", "groups": []interface{}{"dev"}}
+	tests := []struct {
+		contextName string
+		want        string
+	}{
+		{"", "prod/jane@example.com"},
+		{"static", "static"},
+		{"prod-{{ .preferred_username }}", "prod-jane"},
+		{"prod-{{ .groups.0 }}", "prod-dev"},
+		// Missing claims fall back to the default name
+		{"prod-{{ .missing }}", "prod/jane@example.com"},
+	}
+	for _, tt := range tests {
+		c := config.Cluster{Name: "prod", ContextName: tt.contextName}
+		if got := s.ContextName(c, "jane@example.com", cl); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.contextName, got, tt.want)
+		}
+	}
+}
+
+func TestKubeconfigContextName(t *testing.T) {
+	s := New(&config.App{})
+	k := KubeUserInfo{IDToken: "token", UsernameClaim: "jane", Claims: claims.Claims{"team": "a"}}
+	clusters := []config.Cluster{{Name: "prod", Server: "https://prod", ContextName: "{{ .team }}-prod"}}
+	kc := s.Kubeconfig(k, clusters, "prod")
+	if len(kc.Contexts) != 1 || kc.Contexts[0].Name != "a-prod" || kc.CurrentContext != "a-prod" {
+		t.Errorf("unexpected contexts %+v, current context %q", kc.Contexts, kc.CurrentContext)
+	}
+}
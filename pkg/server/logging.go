@@ -19,6 +19,8 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -37,22 +39,26 @@ func (lw *loggingResponseWriter) WriteHeader(code int) {
 }
 
 // LoggingHandler catch requests,
-// add metadata and log user requests
-func LoggingHandler(next http.Handler) http.Handler {
+// add metadata and log user requests.
+// Query parameters listed in cfg.RedactQueryParams
+// are redacted from logged urls
+func LoggingHandler(next http.Handler, cfg *config.Log) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		lw := newLoggingResponseWriter(w)
 		t1 := time.Now()
 		next.ServeHTTP(lw, r)
 		t2 := time.Now()
-		log.WithFields(log.Fields{
+		var redactParams []string
+		if cfg != nil {
+			redactParams = cfg.RedactQueryParams
+		}
+		log.WithContext(r.Context()).WithFields(log.Fields{
 			"method":           r.Method,
-			"path":             r.URL.String(),
+			"path":             logging.RedactQuery(r.URL, redactParams),
 			"request_duration": t2.Sub(t1).String(),
 			"protocol":         r.Proto,
 			"remote_address":   r.RemoteAddr,
 			"code":             lw.statusCode,
 		}).Info()
-		PromIncRequest(lw.statusCode, r.Method)
-		PromAddRequestDuration(lw.statusCode, r.Method, t2.Sub(t1))
 	})
 }
@@ -0,0 +1,96 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRequestIDHandler(t *testing.T) {
+	var ctxID string
+	h := RequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID = logging.RequestID(r.Context())
+	}))
+	tests := map[string]bool{
+		"":                  false,
+		"client-id-1":       true,
+		"invalid id\r\nfoo": false,
+	}
+	for header, kept := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set(logging.RequestIDHeader, header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		id := w.Header().Get(logging.RequestIDHeader)
+		if id != ctxID {
+			t.Errorf("%q: response ID %q differs from context ID %q", header, id, ctxID)
+		}
+		if kept != (id == header) {
+			t.Errorf("%q: got request ID %q", header, id)
+		}
+		if !logging.ValidRequestID(id) {
+			t.Errorf("%q: invalid request ID %q", header, id)
+		}
+	}
+}
+
+func TestLoggingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	out, formatter, hooks := logger.Out, logger.Formatter, logger.ReplaceHooks(make(log.LevelHooks))
+	t.Cleanup(func() {
+		logger.SetOutput(out)
+		logger.SetFormatter(formatter)
+		logger.ReplaceHooks(hooks)
+	})
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.AddHook(logging.ContextHook{})
+
+	cfg := &config.Log{RedactQueryParams: config.DefaultRedactQueryParams}
+	h := RequestIDHandler(LoggingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), cfg))
+	req := httptest.NewRequest(http.MethodGet, "/callback?code=secret-code&state=secret-state&foo=bar", nil)
+	req.Header.Set(logging.RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if got, want := entry["path"], "/callback?code=REDACTED&foo=bar&state=REDACTED"; got != want {
+		t.Errorf("path: got %v, want %s", got, want)
+	}
+	if got := entry[logging.RequestIDField]; got != "req-1" {
+		t.Errorf("request ID: got %v, want req-1", got)
+	}
+	if got := entry["code"]; got != float64(http.StatusTeapot) {
+		t.Errorf("code: got %v, want %d", got, http.StatusTeapot)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Errorf("secrets logged: %s", buf.String())
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/audit"
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/oidctest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+)
+
+// hiddenInput matches the hidden inputs of the token page forms
+var hiddenInput = regexp.MustCompile(`<input type="hidden" name="([a-z_]+)" value="([^"]*)">`)
+
+// loginServer starts an embedded provider and a loginapp
+// server using it, settings override the server
+// configuration. Watches stop when the test ends
+func loginServer(t *testing.T, settings map[string]interface{}) (*Server, *httptest.Server) {
+	return providerLoginServer(t, settings, nil)
+}
+
+// providerLoginServer is loginServer, with the provider
+// handler wrapped by wrap if not nil
+func providerLoginServer(t *testing.T, settings map[string]interface{}, wrap func(http.Handler) http.Handler) (*Server, *httptest.Server) {
+	provider := httptest.NewUnstartedServer(nil)
+	p, err := oidctest.New("http://"+provider.Listener.Addr().String(), oidctest.Config{AutoLogin: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Config.Handler = p
+	if wrap != nil {
+		provider.Config.Handler = wrap(p)
+	}
+	provider.Start()
+	t.Cleanup(provider.Close)
+
+	ts := httptest.NewUnstartedServer(nil)
+	t.Cleanup(ts.Close)
+	t.Cleanup(viper.Reset)
+	viper.Set("name", "test")
+	viper.Set("listen", ts.Listener.Addr().String())
+	viper.Set("secret", "test")
+	viper.Set("oidc.issuer.url", p.Issuer())
+	viper.Set("oidc.issuer.insecureSkipVerify", true)
+	viper.Set("oidc.client.id", p.Config.ClientID)
+	viper.Set("oidc.client.secret", p.Config.ClientSecret)
+	viper.Set("oidc.client.redirectURL", "http://"+ts.Listener.Addr().String()+"/callback")
+	viper.Set("oidc.scopes", config.DefaultScopes)
+	viper.Set("oidc.offlineAsScope", true)
+	viper.Set("clusters", []map[string]interface{}{{"name": "prod", "server": "https://prod:6443", "insecure-skip-tls-verify": true}})
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+	cfg := new(config.App)
+	if err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(cfg)
+	s.Registerer = prometheus.NewRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := s.Setup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.Handler = s.Handler()
+	ts.Start()
+	return s, ts
+}
+
+// login follows the login redirects and returns the
+// hidden inputs of the token page, and the page
+func login(t *testing.T, ts *httptest.Server) (url.Values, string) {
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/callback" {
+		t.Fatalf("login ended on %s with status %d: %s", resp.Request.URL, resp.StatusCode, body)
+	}
+	inputs := make(url.Values)
+	for _, m := range hiddenInput.FindAllStringSubmatch(string(body), -1) {
+		if inputs.Get(m[1]) == "" {
+			inputs.Set(m[1], html.UnescapeString(m[2]))
+		}
+	}
+	return inputs, string(body)
+}
+
+func TestLogin(t *testing.T) {
+	s, ts := loginServer(t, nil)
+	inputs, _ := login(t, ts)
+
+	idToken := inputs.Get("id_token")
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid id token %q", idToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+		Email  string `json:"email"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != s.Config.OIDC.Issuer.URL || claims.Email != "admin@example.com" {
+		t.Errorf("unexpected id token claims %s", payload)
+	}
+	if inputs.Get("refresh_token") == "" || inputs.Get("refresh_token_mac") == "" {
+		t.Errorf("no refresh token in token page: %v", inputs)
+	}
+	if n := testutil.ToFloat64(s.loginMetrics.completed.WithLabelValues(s.Config.OIDC.Issuer.URL)); n != 1 {
+		t.Errorf("got %v logins completed, want 1", n)
+	}
+}
+
+func TestLoginKubeconfig(t *testing.T) {
+	s, ts := loginServer(t, nil)
+	inputs, _ := login(t, ts)
+	inputs.Set("cluster", "prod")
+
+	resp, err := http.PostForm(ts.URL+"/kubeconfig", inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", resp.StatusCode, body)
+	}
+	for _, want := range []string{inputs.Get("id_token"), inputs.Get("refresh_token"), s.Config.OIDC.Client.Secret} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("kubeconfig does not contain %q:\n%s", want, body)
+		}
+	}
+
+	// The client secret is not embedded with a forged refresh token
+	inputs.Set("refresh_token", "forged")
+	resp, err = http.PostForm(ts.URL+"/kubeconfig", inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || strings.Contains(string(body), s.Config.OIDC.Client.Secret) {
+		t.Errorf("forged refresh token accepted with status %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestLoginIdentity(t *testing.T) {
+	_, ts := loginServer(t, nil)
+	_, page := login(t, ts)
+	for _, want := range []string{
+		// Groups of the "groups" claim
+		`<span class="label label-default">admins</span>`,
+		// Scopes granted by the provider
+		`<span class="label label-info">offline_access</span>`,
+		// Decoded ID token header and claims
+		`&#34;alg&#34;: &#34;RS256&#34;`,
+		`&#34;preferred_username&#34;: &#34;admin&#34;`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("token page does not contain %s", want)
+		}
+	}
+}
+
+func TestLoginAudit(t *testing.T) {
+	var tokens []string
+	s, ts := providerLoginServer(t, nil, func(next http.Handler) http.Handler {
+		// Record the tokens issued by the provider
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			next.ServeHTTP(rec, r)
+			var resp map[string]interface{}
+			if strings.HasSuffix(r.URL.Path, "/token") && json.Unmarshal(rec.Body.Bytes(), &resp) == nil {
+				for _, key := range []string{"access_token", "id_token", "refresh_token"} {
+					if token, ok := resp[key].(string); ok {
+						tokens = append(tokens, token)
+					}
+				}
+			}
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+		})
+	})
+	path := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	if s.audit, err = audit.NewLogger(config.Audit{File: config.AuditFile{Path: path}}, prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+	login(t, ts)
+	if err := s.audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("got %d tokens issued, want access, ID and refresh tokens", len(tokens))
+	}
+	for _, token := range tokens {
+		if strings.Contains(string(b), token) {
+			t.Errorf("audit event contains a raw token: %s", b)
+		}
+	}
+	var e audit.Event
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != audit.EventCredentialsIssued || e.Issuer != s.Config.OIDC.Issuer.URL || !e.RefreshTokenIssued ||
+		!strings.HasPrefix(e.TokenFingerprint, "sha256:") || len(e.Clusters) != 1 || e.Clusters[0] != "prod" {
+		t.Errorf("unexpected audit event %s", b)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Output is a parsed custom output (web.outputs).
+// Outputs are plain text, escaped when included in the page
+type Output struct {
+	config.WebOutput
+	tmpl *template.Template
+}
+
+// RenderedOutput is an output rendered for a user
+type RenderedOutput struct {
+	config.WebOutput
+	Content string
+	// Error is set if the output cannot be rendered
+	Error string
+}
+
+// NewOutputs parses the templates of outputs
+func NewOutputs(outputs []config.WebOutput) ([]Output, error) {
+	var parsed []Output
+	for _, o := range outputs {
+		text := o.Template
+		if o.TemplateFile != "" {
+			b, err := ioutil.ReadFile(o.TemplateFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read output %q template: %v", o.Name, err)
+			}
+			text = string(b)
+		}
+		tmpl, err := template.New(o.Name).Funcs(template.FuncMap(TemplateFuncs())).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse output %q template: %v", o.Name, err)
+		}
+		parsed = append(parsed, Output{WebOutput: o, tmpl: tmpl})
+	}
+	return parsed, nil
+}
+
+// RenderOutputs renders outputs with the template data model of k.
+// An output which cannot be rendered does not prevent
+// other outputs from being rendered
+func (s *Server) RenderOutputs(k KubeUserInfo) []RenderedOutput {
+	rendered := make([]RenderedOutput, 0, len(s.outputs))
+	for _, o := range s.outputs {
+		r := RenderedOutput{WebOutput: o.WebOutput}
+		if content, err := s.renderOutput(o, k); err != nil {
+			log.Errorf("failed to render output %q: %v", o.Name, err)
+			r.Error = "output rendering failed, please contact your administrator"
+		} else {
+			r.Content = content
+		}
+		rendered = append(rendered, r)
+	}
+	return rendered
+}
+
+func (s *Server) renderOutput(o Output, k KubeUserInfo) (string, error) {
+	b := new(bytes.Buffer)
+	if err := o.tmpl.Execute(b, k.Data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
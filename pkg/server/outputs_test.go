@@ -0,0 +1,104 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+func TestNewOutputs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "envrc.tmpl")
+	if err := ioutil.WriteFile(file, []byte(`export USER={{ shellQuote .User.Name }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := NewOutputs([]config.WebOutput{
+		{Name: "inline", Template: `{{ .User.Name }}`},
+		{Name: "file", TemplateFile: file},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 || outputs[1].tmpl == nil {
+		t.Fatalf("unexpected outputs %+v", outputs)
+	}
+	for _, o := range []config.WebOutput{
+		{Name: "missing", TemplateFile: filepath.Join(t.TempDir(), "missing")},
+		{Name: "invalid", Template: `{{ .User.Name `},
+		{Name: "unknown-func", Template: `{{ unknown .User.Name }}`},
+	} {
+		if _, err := NewOutputs([]config.WebOutput{o}); err == nil || !strings.Contains(err.Error(), o.Name) {
+			t.Errorf("%s: expected an error naming the output, got %v", o.Name, err)
+		}
+	}
+}
+
+func TestRenderOutputs(t *testing.T) {
+	s := New(&config.App{Name: "test"})
+	var err error
+	if s.outputs, err = NewOutputs([]config.WebOutput{
+		{Name: "user", Title: "User", Template: `{{ .User.Name }} {{ .User.Groups | join "," }}`},
+		{Name: "missing-claim", Title: "Missing", Template: `{{ claim "address.country" . }}`},
+		{Name: "kubeconfig", Title: "Kubeconfig", Template: `{{ kubeconfigFor "prod" . | b64enc | b64dec }}`, Filename: "prod.yaml"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	k := benchmarkUserInfo(s)
+	k.Data.User.Name = "<jane>"
+	outputs := s.RenderOutputs(k)
+	if len(outputs) != 3 {
+		t.Fatalf("got %d outputs, want 3", len(outputs))
+	}
+	if o := outputs[0]; o.Content != "<jane> dev" || o.Error != "" {
+		t.Errorf("unexpected user output %+v", o)
+	}
+	// A failing output does not prevent other outputs from being rendered
+	if o := outputs[1]; o.Content != "" || o.Error == "" || strings.Contains(o.Error, "address") {
+		t.Errorf("unexpected missing claim output %+v", o)
+	}
+	if o := outputs[2]; !strings.Contains(o.Content, "server: https://prod:6443") || o.Filename != "prod.yaml" {
+		t.Errorf("unexpected kubeconfig output %+v", o)
+	}
+
+	// Outputs are escaped in the token page
+	registry, err := NewTemplateRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := registry.Get("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Outputs = outputs
+	b := new(bytes.Buffer)
+	if err := tmpl.Execute(b, k); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<a data-toggle="tab" href="#output-user">User</a>`,
+		`<code id="output-user-code">&lt;jane&gt; dev</code>`,
+		`outputDownload('output-kubeconfig-code', 'prod.yaml'`,
+		`<div class="alert alert-warning">output rendering failed`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("token page does not contain %s", want)
+		}
+	}
+}
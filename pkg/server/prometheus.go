@@ -15,58 +15,175 @@
 package server
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
-	"time"
 
-	"github.com/julienschmidt/httprouter"
+	"github.com/fydrah/loginapp/pkg/config"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
 	// MetricsPrefix is used by every exported metrics
 	// as metric name prefix
 	MetricsPrefix = "loginapp_"
+	// unmatchedRoute is the route label of
+	// requests matching no route
+	unmatchedRoute = "unmatched"
 )
 
-var (
-	// TotalRequestCounter is the total number of http request
-	TotalRequestCounter = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: MetricsPrefix + "request_total",
-		Help: "The total number of http request",
-	}, []string{"code", "method"})
+// LoginMetrics are the metrics of login outcomes
+type LoginMetrics struct {
+	started   *prometheus.CounterVec
+	completed *prometheus.CounterVec
+	failed    *prometheus.CounterVec
+	refresh   *prometheus.CounterVec
+}
 
-	// RequestDuration is the duration of http request in seconds
-	RequestDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: MetricsPrefix + "request_duration",
-		Help: "Duration of http request in seconds",
-	}, []string{"code", "method"})
-)
+// NewLoginMetrics registers login metrics to reg
+func NewLoginMetrics(reg prometheus.Registerer) (*LoginMetrics, error) {
+	m := &LoginMetrics{
+		// Logins redirected to the provider
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricsPrefix + "login_started_total",
+			Help: "The total number of logins started",
+		}, []string{"provider"}),
+		// Logins which issued credentials
+		completed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricsPrefix + "login_completed_total",
+			Help: "The total number of logins completed",
+		}, []string{"provider"}),
+		// Failed logins, reason is the error
+		// type (ex: "state_mismatch")
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricsPrefix + "login_failed_total",
+			Help: "The total number of logins failed, by reason",
+		}, []string{"provider", "reason"}),
+		// Logins which issued a refresh token
+		refresh: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricsPrefix + "refresh_tokens_issued_total",
+			Help: "The total number of refresh tokens issued",
+		}, []string{"provider"}),
+	}
+	for _, c := range []prometheus.Collector{m.started, m.completed, m.failed, m.refresh} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %v", err)
+		}
+	}
+	return m, nil
+}
+
+// HTTPMetrics are the metrics of requests
+// served by the application listener
+type HTTPMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	size     *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
 
-// PrometheusMetrics setup prometheus metrics exporter
-func PrometheusMetrics(port int) error {
-	pr := func() *httprouter.Router {
-		r := httprouter.New()
-		r.Handler("GET", "/metrics", promhttp.Handler())
-		return r
+// NewHTTPMetrics registers http metrics to reg. The request
+// duration histogram uses buckets, config.DefaultMetricsBuckets
+// if empty
+func NewHTTPMetrics(reg prometheus.Registerer, buckets []float64) (*HTTPMetrics, error) {
+	if len(buckets) == 0 {
+		buckets = config.DefaultMetricsBuckets
+	}
+	m := &HTTPMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: MetricsPrefix + "request_total",
+			Help: "The total number of http request",
+		}, []string{"route", "code", "method"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    MetricsPrefix + "request_duration_seconds",
+			Help:    "Duration of http request in seconds",
+			Buckets: buckets,
+		}, []string{"route", "code", "method"}),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    MetricsPrefix + "response_size_bytes",
+			Help:    "Size of http responses in bytes",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		}, []string{"route", "code", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: MetricsPrefix + "requests_in_flight",
+			Help: "Number of http requests being served",
+		}),
 	}
+	for _, c := range []prometheus.Collector{m.requests, m.duration, m.size, m.inFlight} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %v", err)
+		}
+	}
+	return m, nil
+}
+
+// InstrumentRoute instruments requests served by next with
+// the route label, route being the pattern of the router
+func (m *HTTPMetrics) InstrumentRoute(route string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerResponseSize(m.size.MustCurryWith(labels), next),
+		),
+	)
+}
 
-	if err := fmt.Errorf("%v", http.ListenAndServe(fmt.Sprintf(":%v", port), LoggingHandler(pr()))); err != nil {
-		return err
+// InstrumentInFlight counts requests being served by next
+func (m *HTTPMetrics) InstrumentInFlight(next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(m.inFlight, next)
+}
+
+// ServeAdmin starts the admin listener on address, serving
+// the prometheus router (metrics and debug endpoints).
+// Requests to the admin listener are not instrumented
+func (s *Server) ServeAdmin(address string, tlsCfg config.TLS) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to start admin listener: %v", err)
 	}
+	scheme := "http"
+	if tlsCfg.Enabled {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.Cert, tlsCfg.Key)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to load admin listener tls certificate: %v", err)
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+		scheme = "https"
+	}
+	log.Infof("admin listener on %s://%s", scheme, address)
+	go func() {
+		if err := http.Serve(ln, s.adminHandler()); err != nil {
+			log.Errorf("admin listener stopped: %v", err)
+		}
+	}()
 	return nil
 }
 
-// PromIncRequest increase request count for a given
-// return code and http method
-func PromIncRequest(sc int, m string) {
-	TotalRequestCounter.With(prometheus.Labels{"code": fmt.Sprintf("%v", sc), "method": m}).Inc()
+// adminHandler returns the handler of the admin listener
+func (s *Server) adminHandler() http.Handler {
+	return LoggingHandler(s.promrouter, &s.Config.Log)
 }
 
-// PromAddRequestDuration append request duration for
-// a given return code and http method
-func PromAddRequestDuration(sc int, m string, d time.Duration) {
-	RequestDuration.With(prometheus.Labels{"code": fmt.Sprintf("%v", sc), "method": m}).Set(d.Seconds())
+// basicAuth requires the credentials of auth
+// to serve next, if auth.Username is set
+func basicAuth(next http.Handler, auth config.BasicAuth) http.Handler {
+	if auth.Username == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(auth.Username)) == 1
+		validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(auth.Password)) == 1
+		if !ok || !validUsername || !validPassword {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
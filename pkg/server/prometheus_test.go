@@ -0,0 +1,159 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/client"
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsRegisterer(t *testing.T) {
+	// Each server registers its metrics to its own registry
+	for i := 0; i < 2; i++ {
+		reg := prometheus.NewRegistry()
+		if _, err := NewHTTPMetrics(reg, nil); err != nil {
+			t.Fatal(err)
+		}
+		m, err := NewLoginMetrics(reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.New(&config.OIDC{}).Metrics.Register(reg); err != nil {
+			t.Fatal(err)
+		}
+		m.started.WithLabelValues("https://issuer").Inc()
+		if n := testutil.ToFloat64(m.started.WithLabelValues("https://issuer")); n != 1 {
+			t.Errorf("%d: got %v logins started, want 1", i, n)
+		}
+		if _, err := NewLoginMetrics(reg); err == nil {
+			t.Errorf("%d: expected an error registering login metrics twice", i)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	auth := config.BasicAuth{Username: "prom", Password: "secret"}
+	tests := []struct {
+		name     string
+		auth     config.BasicAuth
+		username string
+		password string
+		set      bool
+		want     int
+	}{
+		{"disabled", config.BasicAuth{}, "", "", false, http.StatusOK},
+		{"valid", auth, "prom", "secret", true, http.StatusOK},
+		{"missing", auth, "", "", false, http.StatusUnauthorized},
+		{"bad username", auth, "admin", "secret", true, http.StatusUnauthorized},
+		{"bad password", auth, "prom", "secre", true, http.StatusUnauthorized},
+		{"empty password", auth, "prom", "", true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.set {
+			req.SetBasicAuth(tt.username, tt.password)
+		}
+		w := httptest.NewRecorder()
+		basicAuth(next, tt.auth).ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (challenge != "") {
+			t.Errorf("%s: unexpected WWW-Authenticate header %q", tt.name, challenge)
+		}
+	}
+}
+
+// get requests path on ts, with the credentials of auth if
+// set, and returns the response status and body
+func get(t *testing.T, ts *httptest.Server, path string, auth *config.BasicAuth) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestAdminRoutes(t *testing.T) {
+	auth := &config.BasicAuth{Username: "prom", Password: "secret"}
+	settings := map[string]interface{}{
+		"metrics.enabled":            true,
+		"metrics.path":               "/metrics",
+		"metrics.basicAuth.username": auth.Username,
+		"metrics.basicAuth.password": auth.Password,
+		"debug.enabled":              true,
+		"debug.listen":               "127.0.0.1:9091",
+	}
+	for _, mainListener := range []bool{false, true} {
+		settings["metrics.mainListener"] = mainListener
+		s, ts := loginServer(t, settings)
+		admin := httptest.NewServer(s.adminHandler())
+		defer admin.Close()
+
+		metrics := admin
+		if mainListener {
+			metrics = ts
+		}
+		if code, _ := get(t, metrics, "/metrics", nil); code != http.StatusUnauthorized {
+			t.Errorf("mainListener=%t: got status %d for metrics without credentials, want 401", mainListener, code)
+		}
+		code, body := get(t, metrics, "/metrics", auth)
+		if code != http.StatusOK {
+			t.Errorf("mainListener=%t: got status %d for metrics, want 200", mainListener, code)
+		}
+		// Scrapes are not instrumented
+		if !strings.Contains(body, "loginapp_requests_in_flight 0") || strings.Contains(body, `route="/metrics"`) {
+			t.Errorf("mainListener=%t: metrics requests are instrumented:\n%s", mainListener, body)
+		}
+		if !mainListener {
+			if code, _ := get(t, ts, "/metrics", auth); code != http.StatusNotFound {
+				t.Errorf("got status %d for metrics on the application listener, want 404", code)
+			}
+		}
+
+		// Debug endpoints are only served by the admin listener
+		if code, _ := get(t, admin, "/version", nil); code != http.StatusUnauthorized {
+			t.Errorf("mainListener=%t: got status %d for debug without credentials, want 401", mainListener, code)
+		}
+		if code, _ := get(t, admin, "/version", auth); code != http.StatusOK {
+			t.Errorf("mainListener=%t: got status %d for debug, want 200", mainListener, code)
+		}
+		if code, _ := get(t, ts, "/version", auth); code != http.StatusNotFound {
+			t.Errorf("mainListener=%t: got status %d for debug on the application listener, want 404", mainListener, code)
+		}
+	}
+}
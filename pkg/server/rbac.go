@@ -0,0 +1,195 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/kube"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultRBACPreviewTimeout is the default timeout
+	// for the RBAC preview of a cluster
+	DefaultRBACPreviewTimeout = 3 * time.Second
+)
+
+// ClusterPermissions is the RBAC preview of a cluster
+type ClusterPermissions struct {
+	Cluster      string
+	ClusterAdmin bool
+	Namespaces   []kube.RulesReview
+	Error        string
+}
+
+// reviewResult is the RBAC preview of the i-th cluster
+type reviewResult struct {
+	i           int
+	permissions ClusterPermissions
+}
+
+// RBACPreview reviews the permissions of the ID token owner on
+// every cluster with RBAC preview enabled. Clusters are reviewed
+// concurrently, errors are reported per cluster. The preview
+// returns once the longest cluster timeout expires, clusters
+// not reviewed by then are reported as timed out
+func (s *Server) RBACPreview(ctx context.Context, idToken string, clusters []config.Cluster) []ClusterPermissions {
+	var (
+		permissions []ClusterPermissions
+		timeout     time.Duration
+	)
+	for _, c := range clusters {
+		if c.RBACPreview.Enabled {
+			permissions = append(permissions, ClusterPermissions{Cluster: c.Name})
+			if t := previewTimeout(c); t > timeout {
+				timeout = t
+			}
+		}
+	}
+	if len(permissions) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results := make(chan reviewResult, len(permissions))
+	i := 0
+	for _, c := range clusters {
+		if !c.RBACPreview.Enabled {
+			continue
+		}
+		go func(i int, c config.Cluster) {
+			p := ClusterPermissions{Cluster: c.Name}
+			if err := s.reviewCluster(ctx, idToken, c, &p); err != nil {
+				log.WithContext(ctx).Warningf("rbac preview failed for cluster %q: %v", c.Name, err)
+				p.Error = err.Error()
+			}
+			results <- reviewResult{i, p}
+		}(i, c)
+		i++
+	}
+	reviewed := make([]bool, len(permissions))
+	for pending := len(permissions); pending > 0; pending-- {
+		select {
+		case r := <-results:
+			permissions[r.i] = r.permissions
+			reviewed[r.i] = true
+		case <-ctx.Done():
+			// Pending reviews are canceled, the login goes on
+			for i := range permissions {
+				if !reviewed[i] {
+					log.WithContext(ctx).Warningf("rbac preview timed out for cluster %q", permissions[i].Cluster)
+					permissions[i].Error = fmt.Sprintf("the API server did not answer within %s", timeout)
+				}
+			}
+			return permissions
+		}
+	}
+	return permissions
+}
+
+// previewTimeout returns the RBAC preview timeout of c
+func previewTimeout(c config.Cluster) time.Duration {
+	if c.RBACPreview.Timeout > 0 {
+		return c.RBACPreview.Timeout
+	}
+	return DefaultRBACPreviewTimeout
+}
+
+func (s *Server) reviewCluster(ctx context.Context, idToken string, c config.Cluster, p *ClusterPermissions) error {
+	timeout := previewTimeout(c)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	kc, err := s.kubeClients.Get(c.Name, clientConfig(c, "", timeout))
+	if err != nil {
+		return err
+	}
+	kc = kc.WithBearerToken(idToken)
+	if p.ClusterAdmin, err = kc.SelfSubjectAccessReview(ctx, kube.ResourceAttributes{Verb: "*", Group: "*", Resource: "*"}); err != nil {
+		return reviewError(ctx, timeout, err)
+	}
+	namespaces := c.RBACPreview.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{"default"}
+	}
+	for _, ns := range namespaces {
+		review, err := kc.SelfSubjectRulesReview(ctx, ns)
+		if err != nil {
+			return reviewError(ctx, timeout, err)
+		}
+		p.Namespaces = append(p.Namespaces, *review)
+	}
+	return nil
+}
+
+// reviewError returns a readable error when
+// the review of a cluster timed out
+func reviewError(ctx context.Context, timeout time.Duration, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("the API server did not answer within %s", timeout)
+	}
+	return err
+}
+
+// clientConfig returns the kubernetes client configuration for a cluster
+func clientConfig(c config.Cluster, token string, timeout time.Duration) kube.ClientConfig {
+	return kube.ClientConfig{
+		Server:                c.Server,
+		CertificateAuthority:  c.CertificateAuthority,
+		InsecureSkipTLSVerify: c.InsecureSkipTLSVerify,
+		TLSServerName:         c.TLSServerName,
+		ProxyURL:              c.ProxyURL,
+		BearerToken:           token,
+		Timeout:               timeout,
+	}
+}
+
+// kubeClients are the kubernetes clients of clusters, reused
+// across requests so that connections to API servers are shared
+type kubeClients struct {
+	sync.Mutex
+	clients map[string]kubeClient
+}
+
+type kubeClient struct {
+	config kube.ClientConfig
+	client *kube.Client
+}
+
+// Get returns the client of cluster for cfg. The client is
+// replaced, and its idle connections closed, when cfg changes
+func (k *kubeClients) Get(cluster string, cfg kube.ClientConfig) (*kube.Client, error) {
+	k.Lock()
+	defer k.Unlock()
+	if c, ok := k.clients[cluster]; ok {
+		if c.config == cfg {
+			return c.client, nil
+		}
+		c.client.HTTPClient.CloseIdleConnections()
+	}
+	client, err := kube.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if k.clients == nil {
+		k.clients = make(map[string]kubeClient)
+	}
+	k.clients[cluster] = kubeClient{config: cfg, client: client}
+	return client, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/kube"
+)
+
+func TestKubeClientsReuse(t *testing.T) {
+	var k kubeClients
+	cfg := kube.ClientConfig{Server: "https://a", Timeout: time.Second}
+	c1, err := k.Get("a", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := k.Get("a", cfg)
+	if c1 != c2 {
+		t.Errorf("client not reused for the same configuration")
+	}
+	cfg.Server = "https://b"
+	c3, _ := k.Get("a", cfg)
+	if c3 == c1 || c3.Server != "https://b" {
+		t.Errorf("client not replaced on configuration change")
+	}
+}
+
+func TestRBACPreviewTimeout(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":{"allowed":true,"resourceRules":[]}}`))
+	}))
+	defer fast.Close()
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	s := New(&config.App{})
+	clusters := []config.Cluster{
+		{Name: "fast", Server: fast.URL, RBACPreview: config.ClusterRBACPreview{Enabled: true}},
+		{Name: "disabled", Server: slow.URL},
+		{Name: "slow", Server: slow.URL, RBACPreview: config.ClusterRBACPreview{Enabled: true, Timeout: 100 * time.Millisecond}},
+	}
+	start := time.Now()
+	permissions := s.RBACPreview(context.Background(), "token", clusters)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("rbac preview took %s", d)
+	}
+	if len(permissions) != 2 {
+		t.Fatalf("got %d cluster permissions, want 2", len(permissions))
+	}
+	if p := permissions[0]; p.Cluster != "fast" || p.Error != "" || !p.ClusterAdmin || len(p.Namespaces) != 1 {
+		t.Errorf("unexpected fast cluster permissions %+v", p)
+	}
+	if p := permissions[1]; p.Cluster != "slow" || !strings.Contains(p.Error, "did not answer within 100ms") {
+		t.Errorf("unexpected slow cluster permissions %+v", p)
+	}
+}
@@ -16,36 +16,61 @@ package server
 
 import (
 	"net/http"
-	"os"
 
-	"github.com/gobuffalo/packr/v2"
+	"github.com/fydrah/loginapp/web"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// GetAssetsFS returns http.FyleSystem for assets
+// GetAssetsFS returns http.FyleSystem for assets. Files found
+// in the assets directory override embedded assets
 func (s *Server) GetAssetsFS() http.FileSystem {
-	assetsDir, err := os.Stat(s.Config.Web.AssetsDir)
-	if err != nil || !assetsDir.IsDir() {
-		log.Debug("assets directory not found. using embedded assets")
-		return packr.New("assets", "../../web/assets/")
-	} else {
-		log.Debug("assets directory found. using assets from directory")
-		return http.Dir(s.Config.Web.AssetsDir)
-	}
+	return http.FS(web.Layered(s.Config.Web.AssetsDir, web.Assets()))
 }
 
 // Routes setup the server router
 func (s *Server) Routes() {
-	s.router.GET("/", s.HandleLogin)
-	s.router.GET("/callback", s.HandleGetCallback)
-	s.router.GET("/healthz", s.HandleGetHealthz)
-	s.router.ServeFiles("/assets/*filepath", s.GetAssetsFS())
+	s.handle("GET", "/", s.HandleLogin)
+	s.handle("GET", "/callback", s.HandleGetCallback)
+	s.handle("POST", "/kubeconfig", s.HandlePostKubeconfig)
+	s.handle("GET", "/healthz", s.HandleGetHealthz)
+	// Same as httprouter.Router.ServeFiles, with metrics
+	fileServer := http.FileServer(s.GetAssetsFS())
+	s.handle("GET", "/assets/*filepath", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// Keep the original path for logs
+		r = r.Clone(r.Context())
+		r.URL.Path = ps.ByName("filepath")
+		fileServer.ServeHTTP(w, r)
+	})
+	s.router.NotFound = s.metrics.InstrumentRoute(unmatchedRoute, http.NotFoundHandler())
+	s.router.MethodNotAllowed = s.metrics.InstrumentRoute(unmatchedRoute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}))
 	log.Debug("routes loaded")
 }
 
-// PrometheusRoutes setup the prometheus router
+// handle registers h for method and path,
+// instrumented with path as route label
+func (s *Server) handle(method string, path string, h httprouter.Handle) {
+	spanName := method + " " + path
+	s.router.Handler(method, path, s.metrics.InstrumentRoute(path, otelhttp.WithRouteTag(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetName(spanName)
+		h(w, r, httprouter.ParamsFromContext(r.Context()))
+	}))))
+}
+
+// PrometheusRoutes setup the prometheus router, served by
+// the metrics listener or by the application listener.
+// Metrics are gathered from Registerer if it is a Gatherer
 func (s *Server) PrometheusRoutes() {
-	s.promrouter.Handler("GET", "/metrics", promhttp.Handler())
+	handler := promhttp.Handler()
+	if g, ok := s.Registerer.(prometheus.Gatherer); ok && s.Registerer != prometheus.DefaultRegisterer {
+		handler = promhttp.InstrumentMetricHandler(s.Registerer, promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	}
+	s.promrouter.Handler("GET", s.Config.Metrics.Path, basicAuth(handler, s.Config.Metrics.BasicAuth))
 	log.Debug("prometheus routes loaded")
 }
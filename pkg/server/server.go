@@ -16,145 +16,294 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
-	"strconv"
 
+	"github.com/fydrah/loginapp/pkg/audit"
+	"github.com/fydrah/loginapp/pkg/claims"
 	"github.com/fydrah/loginapp/pkg/client"
 	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/logging"
+	"github.com/fydrah/loginapp/pkg/tracing"
 	"github.com/julienschmidt/httprouter"
 	"github.com/oxtoacart/bpool"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 // Server is the description
 // of loginapp web server
 type Server struct {
-	Config     *config.App
-	client     *client.Client
-	router     *httprouter.Router
-	promrouter *httprouter.Router
-	bufpool    *bpool.BufferPool
+	Config  *config.App
+	Version Version
+	// Registerer registers HTTP, login and OIDC
+	// client metrics, prometheus.DefaultRegisterer
+	// by default
+	Registerer   prometheus.Registerer
+	client       *client.Client
+	router       *httprouter.Router
+	promrouter   *httprouter.Router
+	bufpool      *bpool.BufferPool
+	templates    *TemplateRegistry
+	outputs      []Output
+	metrics      *HTTPMetrics
+	loginMetrics *LoginMetrics
+	audit        *audit.Logger
+	clusterCAs   clusterCAs
+	// kubeClients are reused by RBAC previews
+	kubeClients kubeClients
+	// claimsTemplates are the parsed claims
+	// templates of clusters
+	claimsTemplates claimsTemplates
+	discovered      discoveredClusters
 }
 
 // New initialize a new server
 func New(cfg *config.App) *Server {
 	s := new(Server)
 	s.Config = cfg
+	s.Registerer = prometheus.DefaultRegisterer
 	s.router = httprouter.New()
+	s.promrouter = httprouter.New()
 	s.bufpool = bpool.NewBufferPool(64)
+	s.clusterCAs.cas = make(map[string]clusterCA)
+	s.discovered.clusters = make(map[string]map[string]config.Cluster)
 	return s
 }
 
 // ProcessCallback check callback
 // from our IdP after a successful login
-// and return user login information (token, claims, issuer)
-func (s *Server) ProcessCallback(w http.ResponseWriter, r *http.Request) (KubeUserInfo, error) {
+// and return user login information (token, claims, issuer).
+// Returned errors are of type *Error
+func (s *Server) ProcessCallback(r *http.Request) (KubeUserInfo, error) {
 	// Authorization redirect callback from OAuth2 auth flow.
-	if err := callbackFormCheck(w, r, s.Config.Secret); err != nil {
+	if err := callbackFormCheck(r, s.Config.Secret); err != nil {
 		return KubeUserInfo{}, err
 	}
 	token, rawIDToken, idToken, aErr := s.client.AuthCodeToIDToken(r.Context(), r.FormValue("code"))
-	if aErr != nil {
-		return KubeUserInfo{}, aErr
+	if errors.Is(aErr, client.ErrTokenVerification) {
+		return KubeUserInfo{}, NewError(ErrInvalidToken, "", aErr)
+	} else if aErr != nil {
+		return KubeUserInfo{}, NewError(ErrCodeExchange, "", aErr)
 	}
 	jsonClaims, cErr := client.ExtractClaims(idToken)
 	if cErr != nil {
-		return KubeUserInfo{}, cErr
+		return KubeUserInfo{}, NewError(ErrInternal, "", cErr)
 	}
-	// FORMAT: check if "usernameclaim" configured by user exist in response (should be done during init)
-	var usernameClaim interface{}
-	if usernameClaim = jsonClaims[s.Config.Web.MainUsernameClaim]; usernameClaim == nil {
-		msg := fmt.Sprintf("failed to find a claim matching the main_username_claim '%v'", s.Config.Web.MainUsernameClaim)
-		http.Error(w, msg, http.StatusInternalServerError)
-		return KubeUserInfo{}, fmt.Errorf(msg)
+	username, uErr := s.Username(jsonClaims)
+	if uErr != nil {
+		return KubeUserInfo{}, NewError(ErrMissingUsername, "", uErr)
 	}
-	log.Debugf("token issued with claims: %v", jsonClaims)
-	return KubeUserInfo{
+	header, hErr := client.DecodeTokenHeader(rawIDToken)
+	if hErr != nil {
+		return KubeUserInfo{}, NewError(ErrInternal, "", hErr)
+	}
+	log.WithContext(r.Context()).Debugf("token issued with claims: %v", claims.Claims(jsonClaims).Redact(s.Config.Log.RedactClaims, logging.Redacted))
+	clusters := s.Clusters()
+	kc := KubeUserInfo{
 		IDToken:       rawIDToken,
 		RefreshToken:  token.RefreshToken,
 		RedirectURL:   s.Config.OIDC.Issuer.URL,
 		Claims:        jsonClaims,
-		UsernameClaim: usernameClaim.(string),
+		UsernameClaim: username,
 		AppConfig:     s.Config,
-	}, nil
+		IDTokenHeader: header,
+		Groups:        claims.Claims(jsonClaims).Strings(s.Config.Web.GroupsClaim),
+		Scopes:        s.client.GrantedScopes(token),
+		IssuedAt:      idToken.IssuedAt,
+		Expiry:        idToken.Expiry,
+		Clusters:      clusters,
+		Permissions:   s.RBACPreview(r.Context(), rawIDToken, clusters),
+		Namespaces:    s.Namespaces(clusters, jsonClaims),
+	}
+	kubeconfig, kErr := s.FullKubeconfig(kc)
+	if kErr != nil {
+		return KubeUserInfo{}, NewError(ErrInternal, "", kErr)
+	}
+	kc.Kubeconfig = kubeconfig
+	if kc.RefreshToken != "" {
+		kc.RefreshTokenMAC = client.RefreshTokenMAC(kc.IDToken, kc.RefreshToken, s.Config.Secret)
+	}
+	kc.Data = s.TemplateData(kc)
+	kc.Outputs = s.RenderOutputs(kc)
+	kc.Snippets = s.Snippets(kc)
+	kc.Shell = SelectedShell(r)
+	s.audit.Log(r.Context(), s.AuditEvent(r, kc))
+	return kc, nil
+}
+
+// AuditEvent returns the audit event of
+// credentials issued during a callback
+func (s *Server) AuditEvent(r *http.Request, k KubeUserInfo) audit.Event {
+	jti, _ := k.Claims.String("jti")
+	sub, _ := k.Claims.String("sub")
+	iss, _ := k.Claims.String("iss")
+	clusters := make([]string, 0, len(k.Clusters))
+	for _, c := range k.Clusters {
+		clusters = append(clusters, c.Name)
+	}
+	return audit.Event{
+		Type:               audit.EventCredentialsIssued,
+		RequestID:          logging.RequestID(r.Context()),
+		RemoteAddress:      r.RemoteAddr,
+		Issuer:             iss,
+		ClientID:           s.Config.OIDC.Client.ID,
+		Username:           k.UsernameClaim,
+		Subject:            sub,
+		Groups:             k.Groups,
+		Clusters:           clusters,
+		Scopes:             k.Scopes,
+		RefreshTokenIssued: k.RefreshToken != "",
+		TokenFingerprint:   audit.Fingerprint(k.IDToken, jti),
+		TokenExpiry:        k.Expiry,
+	}
 }
 
-func callbackFormCheck(w http.ResponseWriter, r *http.Request, secret string) error {
-	if errMsg := r.FormValue("error"); errMsg != "" {
-		msg := fmt.Sprintf("%v: %v", errMsg, r.FormValue("error_description"))
-		http.Error(w, msg, http.StatusBadRequest)
-		return fmt.Errorf(msg)
-	}
-	code := r.FormValue("code")
-	if code == "" {
-		msg := fmt.Sprintf("no code in request: %q", r.Form)
-		http.Error(w, msg, http.StatusBadRequest)
-		return fmt.Errorf(msg)
-	}
-	if state, err := strconv.Unquote(r.FormValue("state")); client.VerifyState(r, state, secret) && err != nil {
-		var msg string
-		if err != nil {
-			msg = fmt.Sprintf("unexpected quote error: %v", err)
-		} else {
-			msg = fmt.Sprintf("expected state %v got %q", client.GenerateState(r, secret), state)
+func callbackFormCheck(r *http.Request, secret string) error {
+	if errCode := r.FormValue("error"); errCode != "" {
+		// See https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+		description := r.FormValue("error_description")
+		if description == "" {
+			description = errCode
 		}
-		http.Error(w, msg, http.StatusBadRequest)
-		return fmt.Errorf(msg)
+		return NewError(ErrIDPDenied, description, fmt.Errorf("idp error %q", errCode))
+	}
+	if r.FormValue("code") == "" {
+		return NewError(ErrInvalidRequest, "no authorization code in request", nil)
+	}
+	if !client.VerifyState(r, r.FormValue("state"), secret) {
+		// Never log the expected state: it would let anyone
+		// reading the logs forge a valid callback
+		return NewError(ErrStateMismatch, "", errors.New("callback state does not match the login request"))
 	}
 	return nil
 }
 
 // RenderTemplate renders
 // go-template formatted html page
-func (s *Server) RenderTemplate(w http.ResponseWriter, tmpl *template.Template, data interface{}) {
-
+func (s *Server) RenderTemplate(w http.ResponseWriter, r *http.Request, tmpl *template.Template, data interface{}) {
 	b := s.bufpool.Get()
 	defer s.bufpool.Put(b)
 
-	err := tmpl.Execute(b, data)
+	if err := tmpl.Execute(b, data); err != nil {
+		s.RenderError(w, r, fmt.Errorf("error rendering template %s: %v", tmpl.Name(), err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	b.WriteTo(w)
+}
 
-	if err != nil {
-		log.Errorf("error rendering template %s: %s", tmpl.Name(), err)
-		w.WriteHeader(http.StatusInternalServerError)
-		errorTmplStr, err := s.GetTemplateStr("error")
-		if err != nil {
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+// Handler returns the handler of the application listener
+func (s *Server) Handler() http.Handler {
+	h := s.metrics.InstrumentInFlight(s.router)
+	if s.Config.Metrics.Enabled && s.Config.Metrics.MainListener {
+		h = s.metricsHandler(h)
+	}
+	return TracingHandler(RequestIDHandler(LoggingHandler(h, &s.Config.Log)), s.Config.Log.RedactQueryParams)
+}
+
+// metricsHandler serves metrics with the prometheus router, and
+// other requests with next. Metrics requests are not instrumented,
+// as on the metrics listener
+func (s *Server) metricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == s.Config.Metrics.Path {
+			s.promrouter.ServeHTTP(w, r)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		errorTmpl := template.New("error")
-		errorTmpl.Parse(errorTmplStr)
-		errorTmpl.Execute(w, nil)
+// Setup loads templates and outputs, sets up the OIDC client
+// and routes, and starts clusters discovery. Listeners are
+// not started, requests are served by Handler. Watches of
+// templates and clusters stop when ctx is done
+func (s *Server) Setup(ctx context.Context) error {
+	var err error
+	if s.templates, err = NewTemplateRegistry(s.Config.Web.TemplatesDir); err != nil {
+		return err
+	}
+	if err := s.templates.Watch(ctx); err != nil {
+		return err
+	}
+	if s.outputs, err = NewOutputs(s.Config.Web.Outputs); err != nil {
+		return err
+	}
+	if err := CheckErrorsConfig(s.Config.Web.Errors); err != nil {
+		return err
+	}
+	if s.metrics, err = NewHTTPMetrics(s.Registerer, s.Config.Metrics.Buckets); err != nil {
+		return err
+	}
+	if s.loginMetrics, err = NewLoginMetrics(s.Registerer); err != nil {
+		return err
+	}
+	s.client = client.New(&s.Config.OIDC)
+	if err := s.client.Metrics.Register(s.Registerer); err != nil {
+		return err
+	}
+	if s.Config.Metrics.Enabled {
+		s.PrometheusRoutes()
+	}
+	if s.Config.Debug.Enabled {
+		s.DebugRoutes()
+	}
+	s.Routes()
+	if err := s.client.Setup(); err != nil {
+		return err
+	}
 
-	} else {
-		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
-		b.WriteTo(w)
+	// Discover clusters from kubernetes objects
+	if s.Config.Discovery.Enabled {
+		s.WatchClusters(ctx)
 	}
+
+	// Discover clusters certificate authorities, without
+	// delaying the start on unreachable clusters
+	go s.WatchClusterCAs(ctx)
+	return nil
 }
 
 // Run launch app
 func (s *Server) Run() error {
-	s.client = client.New(&s.Config.OIDC)
-	s.Routes()
-	if err := s.client.Setup(); err != nil {
+	shutdownTracing, err := tracing.Setup(context.Background(), s.Config.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+	if s.audit, err = audit.NewLogger(s.Config.Audit, s.Registerer); err != nil {
+		return err
+	}
+	defer s.audit.Close()
+	if err := s.Setup(context.Background()); err != nil {
 		return err
 	}
 
-	// Start prometheus metric exporter
-	log.Infof("export metric on http://0.0.0.0:%v", s.Config.Metrics.Port)
-	go PrometheusMetrics(s.Config.Metrics.Port)
+	// Start the admin listener, debug endpoints share
+	// the metrics listener if any
+	if s.Config.Metrics.Enabled && !s.Config.Metrics.MainListener {
+		if err := s.ServeAdmin(s.Config.Metrics.Address(), s.Config.Metrics.TLS); err != nil {
+			return err
+		}
+	} else if s.Config.Debug.Enabled {
+		if err := s.ServeAdmin(s.Config.Debug.Listen, config.TLS{}); err != nil {
+			return err
+		}
+	}
 
 	// Run
 	if s.Config.TLS.Enabled {
 		log.Infof("listening on https://%s", s.Config.Listen)
-		if err := fmt.Errorf("%v", http.ListenAndServeTLS(s.Config.Listen, s.Config.TLS.Cert, s.Config.TLS.Key, LoggingHandler(s.router))); err != nil {
+		if err := fmt.Errorf("%v", http.ListenAndServeTLS(s.Config.Listen, s.Config.TLS.Cert, s.Config.TLS.Key, s.Handler())); err != nil {
 			return err
 		}
 	} else {
 		log.Infof("listening on http://%s", s.Config.Listen)
-		if err := fmt.Errorf("%v", http.ListenAndServe(s.Config.Listen, LoggingHandler(s.router))); err != nil {
+		if err := fmt.Errorf("%v", http.ListenAndServe(s.Config.Listen, s.Handler())); err != nil {
 			return err
 		}
 	}
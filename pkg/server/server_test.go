@@ -0,0 +1,44 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/client"
+)
+
+func TestCallbackFormCheckStateMismatch(t *testing.T) {
+	r := httptest.NewRequest("GET", "/callback?code=abc&state=forged", nil)
+	err := callbackFormCheck(r, "secret")
+	var e *Error
+	if !errors.As(err, &e) || e.Type != ErrStateMismatch {
+		t.Fatalf("expected a state mismatch error, got %v", err)
+	}
+	if strings.Contains(err.Error(), client.GenerateState(r, "secret")) {
+		t.Errorf("error leaks the expected state: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/callback?code=abc", nil)
+	q := r.URL.Query()
+	q.Set("state", client.GenerateState(r, "secret"))
+	r.URL.RawQuery = q.Encode()
+	if err := callbackFormCheck(r, "secret"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+// ShellCookieName is the cookie remembering
+// the shell selected by the user
+const ShellCookieName = "loginapp_shell"
+
+// Shell renders kubectl snippets for a given shell
+type Shell struct {
+	Name  string
+	Title string
+	// safe matches words which do not need quoting
+	safe  *regexp.Regexp
+	quote func(string) string
+	// continuation ends a line continued on the next one
+	continuation string
+	comment      string
+	// tempFile returns the path of a temporary file, name
+	// contains only characters safe for every shell
+	tempFile func(name string) string
+	// writeFile returns the command writing content to path
+	writeFile func(path, content string) string
+}
+
+// Shells are the shells snippets are rendered for,
+// the first one is the default shell
+var Shells = []Shell{
+	{
+		Name:         "bash",
+		Title:        "Bash/Zsh",
+		safe:         regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`),
+		quote:        shellQuote,
+		continuation: " \\",
+		comment:      "#",
+		tempFile:     func(name string) string { return "/tmp/" + name },
+		writeFile: func(path, content string) string {
+			return fmt.Sprintf("cat <<'EOF' > %s\n%s\nEOF", path, content)
+		},
+	},
+	{
+		Name:         "fish",
+		Title:        "Fish",
+		safe:         regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`),
+		quote:        fishQuote,
+		continuation: " \\",
+		comment:      "#",
+		tempFile:     func(name string) string { return "/tmp/" + name },
+		writeFile: func(path, content string) string {
+			return fmt.Sprintf("printf '%%s\\n' %s > %s", fishQuote(content), path)
+		},
+	},
+	{
+		Name:         "powershell",
+		Title:        "PowerShell",
+		safe:         regexp.MustCompile(`^[A-Za-z0-9+=:./_-]+$`),
+		quote:        powershellQuote,
+		continuation: " `",
+		comment:      "#",
+		tempFile:     func(name string) string { return `"$env:TEMP\` + name + `"` },
+		writeFile: func(path, content string) string {
+			return fmt.Sprintf("@'\n%s\n'@ | Set-Content -Path %s", content, path)
+		},
+	},
+	{
+		Name:         "cmd",
+		Title:        "Windows cmd",
+		safe:         regexp.MustCompile(`^[A-Za-z0-9@+=:,./_\\-]+$`),
+		quote:        cmdQuote,
+		continuation: " ^",
+		comment:      "REM",
+		tempFile:     func(name string) string { return `"%TEMP%\` + name + `"` },
+		writeFile: func(path, content string) string {
+			var lines []string
+			for _, l := range strings.Split(content, "\n") {
+				if l = strings.TrimSpace(l); l != "" {
+					lines = append(lines, "echo "+cmdEchoEscape(l))
+				}
+			}
+			return fmt.Sprintf("(\n%s\n) > %s", strings.Join(lines, "\n"), path)
+		},
+	},
+}
+
+// ShellSnippets are the kubectl snippets of a user for a shell
+type ShellSnippets struct {
+	Shell
+	// Credentials sets the user credentials
+	Credentials string
+	// Clusters sets the cluster and context, per cluster name
+	Clusters map[string]string
+}
+
+// SelectedShell returns the shell remembered in
+// the request cookie, the default shell otherwise
+func SelectedShell(r *http.Request) string {
+	if c, err := r.Cookie(ShellCookieName); err == nil {
+		for _, sh := range Shells {
+			if sh.Name == c.Value {
+				return sh.Name
+			}
+		}
+	}
+	return Shells[0].Name
+}
+
+// Snippets returns the kubectl snippets of k for every shell
+func (s *Server) Snippets(k KubeUserInfo) []ShellSnippets {
+	snippets := make([]ShellSnippets, 0, len(Shells))
+	for _, sh := range Shells {
+		ss := ShellSnippets{
+			Shell:       sh,
+			Credentials: s.credentialsSnippet(sh, k),
+			Clusters:    make(map[string]string, len(k.Clusters)),
+		}
+		for _, c := range k.Clusters {
+			ss.Clusters[c.Name] = clusterSnippet(sh, c, k.UsernameClaim, s.ContextName(c, k.UsernameClaim, k.Claims), k.Namespaces[c.Name])
+		}
+		snippets = append(snippets, ss)
+	}
+	return snippets
+}
+
+// Quote quotes word if it contains characters
+// interpreted by the shell
+func (sh Shell) Quote(word string) string {
+	if sh.safe.MatchString(word) {
+		return word
+	}
+	return sh.quote(word)
+}
+
+// command returns a command with one argument per line
+func (sh Shell) command(lines ...string) string {
+	return strings.Join(lines, sh.continuation+"\n    ")
+}
+
+func (s *Server) credentialsSnippet(sh Shell, k KubeUserInfo) string {
+	issuer, _ := k.Claims.String("iss")
+	args := []string{"kubectl config set-credentials " + sh.Quote(k.UsernameClaim), "--auth-provider oidc"}
+	extraOpts := s.Config.Web.Kubeconfig.ExtraOpts
+	keys := make([]string, 0, len(extraOpts))
+	for key := range extraOpts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	providerArgs := make([]string, 0, len(keys)+5)
+	for _, key := range keys {
+		providerArgs = append(providerArgs, key+"="+extraOpts[key])
+	}
+	providerArgs = append(providerArgs,
+		"idp-issuer-url="+issuer,
+		"client-id="+s.Config.OIDC.Client.ID,
+		"id-token="+k.IDToken,
+	)
+	if k.RefreshToken != "" {
+		providerArgs = append(providerArgs,
+			"client-secret="+s.Config.OIDC.Client.Secret,
+			"refresh-token="+k.RefreshToken,
+		)
+	}
+	for _, arg := range providerArgs {
+		args = append(args, "--auth-provider-arg "+sh.Quote(arg))
+	}
+	return sh.command(args...)
+}
+
+func clusterSnippet(sh Shell, c config.Cluster, username, context, namespace string) string {
+	var snippet []string
+	setCluster := []string{"kubectl config set-cluster " + sh.Quote(c.Name), "--server=" + sh.Quote(c.Server)}
+	if c.CertificateAuthority != "" {
+		caFile := sh.tempFile(filenameUnsafeChars.ReplaceAllString(c.Name, "_") + ".crt")
+		snippet = append(snippet,
+			sh.comment+" Retrieve certificate",
+			sh.writeFile(caFile, strings.TrimSpace(c.CertificateAuthority)),
+		)
+		setCluster = append(setCluster, "--certificate-authority="+caFile, "--embed-certs")
+	}
+	setCluster = append(setCluster, fmt.Sprintf("--insecure-skip-tls-verify=%t", c.InsecureSkipTLSVerify))
+	if c.TLSServerName != "" {
+		setCluster = append(setCluster, "--tls-server-name="+sh.Quote(c.TLSServerName))
+	}
+	snippet = append(snippet, sh.comment+" Create cluster config", sh.command(setCluster...))
+	if c.ProxyURL != "" {
+		snippet = append(snippet, fmt.Sprintf("kubectl config set %s %s", sh.Quote("clusters."+c.Name+".proxy-url"), sh.Quote(c.ProxyURL)))
+	}
+	if c.DisableCompression {
+		snippet = append(snippet, fmt.Sprintf("kubectl config set %s true", sh.Quote("clusters."+c.Name+".disable-compression")))
+	}
+	setContext := []string{
+		"kubectl config set-context " + sh.Quote(context),
+		"--user=" + sh.Quote(username),
+		"--cluster=" + sh.Quote(c.Name),
+	}
+	if namespace != "" {
+		setContext = append(setContext, "--namespace="+sh.Quote(namespace))
+	}
+	snippet = append(snippet, sh.comment+" Create context config", sh.command(setContext...))
+	return strings.Join(snippet, "\n")
+}
+
+// fishQuote quotes s for the fish shell
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// powershellQuote quotes s for PowerShell
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// cmdQuote quotes s for Windows cmd. Quotes are escaped
+// for the arguments parser of the called program and
+// percent signs are doubled to prevent variable expansion
+func cmdQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, `\"`, "%", "%%").Replace(s) + `"`
+}
+
+// cmdEchoEscape escapes s for an unquoted cmd echo
+// line run inside a parenthesized block
+var cmdEchoEscape = strings.NewReplacer(
+	"%", "%%",
+	"^", "^^",
+	"&", "^&",
+	"|", "^|",
+	"<", "^<",
+	">", "^>",
+	"(", "^(",
+	")", "^)",
+).Replace
@@ -0,0 +1,97 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+)
+
+func shellByName(t *testing.T, name string) Shell {
+	t.Helper()
+	for _, sh := range Shells {
+		if sh.Name == name {
+			return sh
+		}
+	}
+	t.Fatalf("shell %q not found", name)
+	return Shell{}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		word string
+		want map[string]string
+	}{
+		{
+			word: "jane",
+			want: map[string]string{"bash": "jane", "fish": "jane", "powershell": "jane", "cmd": "jane"},
+		},
+		{
+			word: "jane doe",
+			want: map[string]string{"bash": "'jane doe'", "fish": "'jane doe'", "powershell": "'jane doe'", "cmd": `"jane doe"`},
+		},
+		{
+			word: "o'brien",
+			want: map[string]string{"bash": `'o'\''brien'`, "fish": `'o\'brien'`, "powershell": "'o''brien'", "cmd": `"o'brien"`},
+		},
+		{
+			word: `say "hi"`,
+			want: map[string]string{"bash": `'say "hi"'`, "fish": `'say "hi"'`, "powershell": `'say "hi"'`, "cmd": `"say \"hi\""`},
+		},
+		{
+			word: "$HOME",
+			want: map[string]string{"bash": "'$HOME'", "fish": "'$HOME'", "powershell": "'$HOME'", "cmd": `"$HOME"`},
+		},
+		{
+			word: "100%PATH%",
+			want: map[string]string{"bash": "100%PATH%", "fish": "100%PATH%", "powershell": "'100%PATH%'", "cmd": `"100%%PATH%%"`},
+		},
+		{
+			word: `C:\Users\jane doe`,
+			want: map[string]string{"bash": `'C:\Users\jane doe'`, "fish": `'C:\\Users\\jane doe'`, "powershell": `'C:\Users\jane doe'`, "cmd": `"C:\Users\jane doe"`},
+		},
+	}
+	for _, sh := range Shells {
+		for _, tt := range tests {
+			want, ok := tt.want[sh.Name]
+			if !ok {
+				t.Fatalf("no expectation for shell %q", sh.Name)
+			}
+			if got := sh.Quote(tt.word); got != want {
+				t.Errorf("%s: Quote(%q) = %s, want %s", sh.Name, tt.word, got, want)
+			}
+		}
+	}
+}
+
+func TestShellWriteFile(t *testing.T) {
+	content := "-----BEGIN CERTIFICATE-----\nMIIB 100% a&b|c<d>e^f (g)\n-----END CERTIFICATE-----"
+	tests := map[string]string{
+		"bash":       "cat <<'EOF' > /tmp/ca.crt\n" + content + "\nEOF",
+		"fish":       "printf '%s\\n' '" + content + "' > /tmp/ca.crt",
+		"powershell": "@'\n" + content + "\n'@ | Set-Content -Path \"$env:TEMP\\ca.crt\"",
+		"cmd": "(\n" +
+			"echo -----BEGIN CERTIFICATE-----\n" +
+			"echo MIIB 100%% a^&b^|c^<d^>e^^f ^(g^)\n" +
+			"echo -----END CERTIFICATE-----\n" +
+			") > \"%TEMP%\\ca.crt\"",
+	}
+	for name, want := range tests {
+		sh := shellByName(t, name)
+		if got := sh.writeFile(sh.tempFile("ca.crt"), content); got != want {
+			t.Errorf("%s: writeFile() =\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// templateReloadDelay groups file events received
+// in a short period of time into a single reload
+const templateReloadDelay = 200 * time.Millisecond
+
+// TemplateNames is the list of templates
+// loaded by the template registry
+var TemplateNames = []string{"token", "error"}
+
+// TemplateRegistry loads and parses templates once, from the
+// templates directory or from embedded templates, and caches them
+type TemplateRegistry struct {
+	sync.RWMutex
+	dir       string
+	templates map[string]*template.Template
+}
+
+// NewTemplateRegistry returns a registry of templates, with
+// templates found in dir overriding embedded templates.
+// It fails if a template cannot be parsed
+func NewTemplateRegistry(dir string) (*TemplateRegistry, error) {
+	t := &TemplateRegistry{dir: dir}
+	if err := t.Load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Load parses every template. The previously loaded
+// templates are kept if a template cannot be parsed
+func (t *TemplateRegistry) Load() error {
+	templates := make(map[string]*template.Template, len(TemplateNames))
+	for _, name := range TemplateNames {
+		tmplStr, err := GetTemplateStr(t.dir, name)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(name).Funcs(TemplateFuncs()).Parse(tmplStr)
+		if err != nil {
+			return fmt.Errorf("failed to parse template %q: %v", name, err)
+		}
+		templates[name] = tmpl
+	}
+	t.Lock()
+	t.templates = templates
+	t.Unlock()
+	return nil
+}
+
+// Get returns the template named name
+func (t *TemplateRegistry) Get(name string) (*template.Template, error) {
+	t.RLock()
+	defer t.RUnlock()
+	tmpl, ok := t.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+	return tmpl, nil
+}
+
+// Watch reloads templates when files of the templates
+// directory change, until ctx is done
+func (t *TemplateRegistry) Watch(ctx context.Context) error {
+	if info, err := os.Stat(t.dir); err != nil || !info.IsDir() {
+		log.Debugf("templates directory %q not found, templates reload disabled", t.dir)
+		return nil
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(t.dir); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		var reload <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.Debugf("templates directory event: %v", e)
+				reload = time.After(templateReloadDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("templates directory watch error: %v", err)
+			case <-reload:
+				reload = nil
+				if err := t.Load(); err != nil {
+					log.Errorf("templates reload failed, still using previous templates: %v", err)
+					continue
+				}
+				log.Info("templates reloaded")
+			}
+		}
+	}()
+	return nil
+}
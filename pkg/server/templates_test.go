@@ -0,0 +1,89 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"html/template"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/fydrah/loginapp/pkg/claims"
+	"github.com/fydrah/loginapp/pkg/config"
+)
+
+// benchmarkUserInfo returns the user info
+// rendered by the token page benchmarks
+func benchmarkUserInfo(s *Server) KubeUserInfo {
+	k := KubeUserInfo{
+		IDToken:       "header.payload.signature",
+		RefreshToken:  "refresh-token",
+		AppConfig:     s.Config,
+		Claims:        claims.Claims{"iss": "https://issuer", "email": "jane@example.com", "groups": []interface{}{"dev"}},
+		UsernameClaim: "jane@example.com",
+		Groups:        []string{"dev"},
+		Scopes:        []string{"openid", "email", "groups"},
+		IssuedAt:      time.Now(),
+		Expiry:        time.Now().Add(time.Hour),
+		Clusters: []config.Cluster{
+			{Name: "prod", Server: "https://prod:6443"},
+			{Name: "staging", Server: "https://staging:6443"},
+		},
+	}
+	k.Namespaces = s.Namespaces(k.Clusters, k.Claims)
+	k.Kubeconfig, _ = s.FullKubeconfig(k)
+	k.Data = s.TemplateData(k)
+	k.Snippets = s.Snippets(k)
+	return k
+}
+
+// BenchmarkTemplateRegistry compares the rendering of the token
+// page with templates cached by the registry, and with templates
+// read and parsed for every request
+func BenchmarkTemplateRegistry(b *testing.B) {
+	s := New(&config.App{Name: "bench"})
+	k := benchmarkUserInfo(s)
+	registry, err := NewTemplateRegistry("")
+	if err != nil {
+		b.Fatal(err)
+	}
+	render := func(b *testing.B, tmpl *template.Template) {
+		if err := tmpl.Execute(ioutil.Discard, k); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tmpl, err := registry.Get("token")
+			if err != nil {
+				b.Fatal(err)
+			}
+			render(b, tmpl)
+		}
+	})
+	b.Run("reparsed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			text, err := GetTemplateStr("", "token")
+			if err != nil {
+				b.Fatal(err)
+			}
+			tmpl, err := template.New("token").Funcs(TemplateFuncs()).Parse(text)
+			if err != nil {
+				b.Fatal(err)
+			}
+			render(b, tmpl)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fydrah/loginapp/pkg/logging"
+	"github.com/fydrah/loginapp/pkg/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// tracedRequestKey is the context key of
+// requests served by TracingHandler
+type tracedRequestKey struct{}
+
+// TracingHandler starts a server span for each request,
+// continuing the W3C trace context of the request if any.
+// Query parameters listed in redactParams are redacted
+// from the span attributes
+func TracingHandler(next http.Handler, redactParams []string) http.Handler {
+	traced := otelhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Serve the original request, with the span context
+		if orig, ok := r.Context().Value(tracedRequestKey{}).(*http.Request); ok {
+			r = orig.WithContext(r.Context())
+		}
+		next.ServeHTTP(w, r)
+	}), tracing.ServiceName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Span attributes are read from a redacted copy
+		r2 := r.Clone(context.WithValue(r.Context(), tracedRequestKey{}, r))
+		r2.URL.RawQuery = logging.RedactRawQuery(r.URL.RawQuery, redactParams)
+		r2.RequestURI = logging.RedactQuery(r.URL, redactParams)
+		traced.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/fydrah/loginapp/pkg/claims"
+)
+
+// Username returns the kubeconfig username for a set of claims.
+// The username is built from web.usernameTemplate if set,
+// from web.mainUsernameClaim otherwise, and is prefixed by
+// web.usernamePrefix so it matches the API server RBAC subject
+func (s *Server) Username(c claims.Claims) (string, error) {
+	var (
+		username string
+		err      error
+	)
+	if s.Config.Web.UsernameTemplate != "" {
+		// Parsed and checked when the configuration is loaded
+		tmpl := s.Config.Web.ParsedUsernameTemplate()
+		if tmpl == nil {
+			return "", fmt.Errorf("web.usernameTemplate is not loaded")
+		}
+		if username, err = tmpl.Render(c); err != nil {
+			return "", fmt.Errorf("failed to render web.usernameTemplate: %v", err)
+		}
+	} else if username, err = c.String(s.Config.Web.MainUsernameClaim); err != nil {
+		return "", fmt.Errorf("failed to find a claim matching the main_username_claim '%v': %v", s.Config.Web.MainUsernameClaim, err)
+	}
+	if username == "" {
+		return "", fmt.Errorf("username built from claims is empty")
+	}
+	return s.Config.Web.UsernamePrefix + username, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fydrah/loginapp/pkg/config"
+	"github.com/fydrah/loginapp/pkg/server"
+	"github.com/fydrah/loginapp/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exportedSpan is the part of spans written
+// by the stdout exporter checked by tests
+type exportedSpan struct {
+	Name       string
+	SpanKind   trace.SpanKind
+	Attributes []struct {
+		Key   string
+		Value struct {
+			Value interface{}
+		}
+	}
+}
+
+// attribute returns the value of the span attribute key
+func (s exportedSpan) attribute(key string) (interface{}, bool) {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value, true
+		}
+	}
+	return nil, false
+}
+
+// exportSpans serves a request to h with tracing set up with the stdout
+// exporter, and returns the spans written once the provider is shut down
+func exportSpans(t *testing.T, h http.Handler, r *http.Request) (string, []exportedSpan) {
+	out, err := ioutil.TempFile(t.TempDir(), "spans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	// The stdout exporter writes to os.Stdout
+	stdout := os.Stdout
+	os.Stdout = out
+	shutdown, err := tracing.Setup(context.Background(), config.Tracing{Enabled: true, Exporter: "stdout", SampleRatio: 1})
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spans []exportedSpan
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	for dec.More() {
+		var s exportedSpan
+		if err := dec.Decode(&s); err != nil {
+			t.Fatalf("failed to decode spans %s: %v", raw, err)
+		}
+		spans = append(spans, s)
+	}
+	return string(raw), spans
+}
+
+func TestTracingHandlerRedactsTarget(t *testing.T) {
+	const uri = "/callback?code=secret-code&state=secret-state&scope=openid"
+	var served *http.Request
+	h := server.TracingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r
+		_, span := tracing.Tracer().Start(r.Context(), "HandleGetCallback")
+		span.End()
+	}), []string{"code", "state"})
+	raw, spans := exportSpans(t, h, httptest.NewRequest("GET", uri, nil))
+
+	// Handlers serve the original request
+	if served == nil {
+		t.Fatal("the request was not served")
+	}
+	if served.URL.Query().Get("code") != "secret-code" || served.RequestURI != uri {
+		t.Errorf("the served request was redacted: %s, %s", served.URL, served.RequestURI)
+	}
+
+	if strings.Contains(raw, "secret-code") || strings.Contains(raw, "secret-state") {
+		t.Errorf("exported spans leak redacted parameters: %s", raw)
+	}
+	var serverSpan *exportedSpan
+	for i, s := range spans {
+		if s.SpanKind == trace.SpanKindServer {
+			serverSpan = &spans[i]
+		}
+	}
+	if serverSpan == nil {
+		t.Fatalf("no server span exported: %s", raw)
+	}
+	if serverSpan.Name != tracing.ServiceName {
+		t.Errorf("got server span %q, want %q", serverSpan.Name, tracing.ServiceName)
+	}
+	target, ok := serverSpan.attribute("http.target")
+	if want := "/callback?code=REDACTED&scope=openid&state=REDACTED"; !ok || target != want {
+		t.Errorf("got http.target %v, want %q", target, want)
+	}
+	if len(spans) != 2 || spans[0].Name != "HandleGetCallback" {
+		t.Errorf("expected the handler span and the server span, got %+v", spans)
+	}
+}
@@ -6,3 +6,45 @@ function kubeconfigDownload(id) {
    a.href = "data:text/plain;charset=utf-8," + encodeURIComponent(document.getElementById(id).textContent);
    a.click();
 };
+
+function outputDownload(id, filename, contentType) {
+   var a = document.body.appendChild(
+       document.createElement("a")
+   );
+   a.download = filename;
+   a.href = "data:" + contentType + ";charset=utf-8," + encodeURIComponent(document.getElementById(id).textContent);
+   a.click();
+};
+
+function shellSelect(shell) {
+   document.cookie = "loginapp_shell=" + encodeURIComponent(shell) + "; path=/; max-age=31536000; SameSite=Lax";
+   var snippets = document.getElementsByClassName("shell-snippet");
+   for (var i = 0; i < snippets.length; i++) {
+       snippets[i].classList.toggle("hidden", snippets[i].getAttribute("data-shell") !== shell);
+   }
+   var selects = document.getElementsByClassName("shell-select");
+   for (var j = 0; j < selects.length; j++) {
+       selects[j].value = shell;
+   }
+};
+
+function tokenCountdown(id) {
+   var el = document.getElementById(id);
+   if (el === null) {
+       return;
+   }
+   var expiry = parseInt(el.getAttribute("data-expiry"), 10);
+   var update = function() {
+       var left = expiry - Math.floor(Date.now() / 1000);
+       if (left <= 0) {
+           el.textContent = "expired";
+           return;
+       }
+       var h = Math.floor(left / 3600);
+       var m = Math.floor((left % 3600) / 60);
+       var s = left % 60;
+       el.textContent = "expires in " + (h > 0 ? h + "h " : "") + m + "m " + s + "s";
+       setTimeout(update, 1000);
+   };
+   update();
+};
@@ -0,0 +1,105 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package web embeds loginapp default assets and templates,
+// and provides filesystems layering user overrides on top of them
+package web
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+//go:embed assets templates
+var embedded embed.FS
+
+// Assets returns the embedded assets filesystem
+func Assets() fs.FS {
+	return sub("assets")
+}
+
+// Templates returns the embedded templates filesystem
+func Templates() fs.FS {
+	return sub("templates")
+}
+
+func sub(dir string) fs.FS {
+	f, err := fs.Sub(embedded, dir)
+	if err != nil {
+		// Cannot happen, dir is embedded
+		panic(err)
+	}
+	return f
+}
+
+// LayeredFS is a filesystem where files of an override
+// directory take precedence over files of a base filesystem.
+// Directories are always read from the base filesystem
+type LayeredFS struct {
+	Dir  string
+	Base fs.FS
+}
+
+// Layered returns a filesystem where files of dir
+// override files of base, file by file
+func Layered(dir string, base fs.FS) *LayeredFS {
+	return &LayeredFS{Dir: dir, Base: base}
+}
+
+// Open implements fs.FS
+func (l *LayeredFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if l.Dir != "" {
+		path := filepath.Join(l.Dir, filepath.FromSlash(name))
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return os.Open(path)
+		}
+	}
+	return l.Base.Open(name)
+}
+
+// Export writes every file of fsys into dir. Existing
+// files are kept unless overwrite is true
+func Export(fsys fs.FS, dir string, overwrite bool) ([]string, error) {
+	var written []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if _, err := os.Stat(target); err == nil && !overwrite {
+			return nil
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		written = append(written, target)
+		return nil
+	})
+	return written, err
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 fydrah
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package web
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbedded(t *testing.T) {
+	if err := fstest.TestFS(Templates(), "token.html", "error.html"); err != nil {
+		t.Error(err)
+	}
+	if err := fstest.TestFS(Assets(), "css/loginapp.css", "js/loginapp.js"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLayered(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "css", "loginapp.css"), []byte("override"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A directory does not shadow the embedded file
+	if err := os.MkdirAll(filepath.Join(dir, "js", "loginapp.js"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l := Layered(dir, Assets())
+
+	b, err := fs.ReadFile(l, "css/loginapp.css")
+	if err != nil || string(b) != "override" {
+		t.Errorf("got %q (%v), want the override", b, err)
+	}
+	embedded, _ := fs.ReadFile(Assets(), "js/loginapp.js")
+	if b, err := fs.ReadFile(l, "js/loginapp.js"); err != nil || string(b) != string(embedded) {
+		t.Errorf("js/loginapp.js is not read from the embedded assets (%v)", err)
+	}
+	for _, name := range []string{"../etc/passwd", "/etc/passwd", "css/../../x"} {
+		if _, err := l.Open(name); err == nil {
+			t.Errorf("%q: expected an invalid path error", name)
+		}
+	}
+	// Without directory, files are the embedded ones
+	if err := fstest.TestFS(Layered("", Templates()), "token.html"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	written, err := Export(Templates(), dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) == 0 {
+		t.Fatal("no file exported")
+	}
+	token := filepath.Join(dir, "token.html")
+	if err := ioutil.WriteFile(token, []byte("custom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Existing files are kept unless overwritten
+	if written, err = Export(Templates(), dir, false); err != nil || len(written) != 0 {
+		t.Errorf("got %v (%v), want no file written", written, err)
+	}
+	if b, _ := ioutil.ReadFile(token); string(b) != "custom" {
+		t.Errorf("token.html overwritten")
+	}
+	if _, err = Export(Templates(), dir, true); err != nil {
+		t.Fatal(err)
+	}
+	embedded, _ := fs.ReadFile(Templates(), "token.html")
+	if b, _ := ioutil.ReadFile(token); string(b) != string(embedded) {
+		t.Errorf("token.html not overwritten")
+	}
+}